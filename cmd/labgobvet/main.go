@@ -0,0 +1,21 @@
+package main
+
+//
+// labgobvet 静态检查 labgob 和 labrpc 的使用，可以单独运行：
+//
+//	go run ./cmd/labgobvet ./...
+//
+// 也可以作为 go vet 的工具：
+//
+//	go build -o /tmp/labgobvet ./cmd/labgobvet
+//	go vet -vettool=/tmp/labgobvet ./...
+//
+
+import (
+	"github.com/gyy0727/mit-6.824/labgobvet"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(labgobvet.Analyzer)
+}
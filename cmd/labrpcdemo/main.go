@@ -0,0 +1,282 @@
+// labrpcdemo 在一个 labrpc.Network 上启动 JunkServer，
+// 分别在可靠和不可靠网络下调用它的处理函数，并打印各方法的结果和网络统计。
+//
+//	go run ./cmd/labrpcdemo [-seed 42] [-calls 100] [-codec gob]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gyy0727/mit-6.824/labgob"
+	"github.com/gyy0727/mit-6.824/labrpc"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// *参数
+type JunkArgs struct {
+	X int
+}
+
+// *响应体
+type JunkReply struct {
+	X string
+}
+
+// *服务器
+type JunkServer struct {
+	mu   sync.Mutex
+	log1 []string
+	log2 []int
+}
+
+// *处理函数1
+func (js *JunkServer) Handler1(args string, reply *int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.log1 = append(js.log1, args)
+	*reply, _ = strconv.Atoi(args)
+}
+
+// *处理函数2
+func (js *JunkServer) Handler2(args int, reply *string) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.log2 = append(js.log2, args)
+	*reply = "handler2-" + strconv.Itoa(args)
+}
+
+// *处理函数3
+func (js *JunkServer) Handler3(args int, reply *int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	time.Sleep(20 * time.Second)
+	*reply = -args
+}
+
+// *处理函数4
+func (js *JunkServer) Handler4(args *JunkArgs, reply *JunkReply) {
+	reply.X = "pointer"
+}
+
+// *处理函数5
+func (js *JunkServer) Handler5(args JunkArgs, reply *JunkReply) {
+	reply.X = "no pointer"
+}
+
+// *处理函数6
+func (js *JunkServer) Handler6(args string, reply *int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	*reply = len(args)
+}
+
+// *处理函数7
+func (js *JunkServer) Handler7(args int, reply *string) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	*reply = ""
+	for i := 0; i < args; i++ {
+		*reply = *reply + "y"
+	}
+}
+
+// *一次检查的结果
+type result struct {
+	name string
+	ok   bool
+	info string
+}
+
+func main() {
+	seed := flag.Int64("seed", 0, "故障注入的随机种子，0 表示随机")
+	calls := flag.Int("calls", 100, "不可靠网络下每个客户端的调用次数")
+	codec := flag.String("codec", "gob", "编码方式：gob、json 或 binary")
+	flag.Parse()
+
+	runtime.GOMAXPROCS(4)
+
+	c := labgob.CodecByName(*codec)
+	if c == nil {
+		log.Fatalf("labrpcdemo: unknown codec %q\n", *codec)
+	}
+
+	var rn *labrpc.Network
+	if *seed != 0 {
+		rn = labrpc.MakeNetworkSeed(*seed)
+	} else {
+		rn = labrpc.MakeNetwork()
+	}
+	defer rn.Cleanup()
+	rn.SetCodec(c)
+
+	js := &JunkServer{}
+	rs := labrpc.MakeServer()
+	rs.AddService(labrpc.MakeService(js))
+	rn.AddServer("server99", rs)
+
+	fmt.Printf("seed %v, codec %v\n\n", rn.Seed(), c.Name())
+
+	fmt.Printf("reliable network:\n")
+	failed := printResults(reliable(rn))
+
+	fmt.Printf("\nunreliable network:\n")
+	failed += printResults(unreliable(rn, 5, *calls))
+
+	fmt.Printf("\nserver deleted during a slow call:\n")
+	failed += printResults(deleted(rn))
+
+	fmt.Printf("\nper-method stats for server99:\n")
+	printStats(rn.ServerMethodStats("server99"))
+	fmt.Printf("\ntotal: %v RPCs, %v bytes, %v duplicates\n",
+		rn.GetTotalCount(), rn.GetTotalBytes(), rn.GetDuplicateCount())
+
+	if failed > 0 {
+		log.Fatalf("labrpcdemo: %v checks failed (seed %v)\n", failed, rn.Seed())
+	}
+}
+
+// * 在可靠网络上调用每个处理函数一次并检查回复。
+func reliable(rn *labrpc.Network) []result {
+	rn.Reliable(true)
+	e := rn.MakeEnd("end1-99")
+	rn.Connect("end1-99", "server99")
+	rn.Enable("end1-99", true)
+
+	results := []result{}
+	check := func(name string, ok bool, got interface{}, want interface{}) {
+		results = append(results, result{name, ok && got == want, fmt.Sprintf("reply %v, want %v", got, want)})
+	}
+
+	{
+		reply := 0
+		ok := e.Call("JunkServer.Handler1", "9099", &reply)
+		check("Handler1", ok, reply, 9099)
+	}
+	{
+		reply := ""
+		ok := e.Call("JunkServer.Handler2", 111, &reply)
+		check("Handler2", ok, reply, "handler2-111")
+	}
+	{
+		reply := JunkReply{}
+		ok := e.Call("JunkServer.Handler4", &JunkArgs{4}, &reply)
+		check("Handler4", ok, reply.X, "pointer")
+	}
+	{
+		reply := JunkReply{}
+		ok := e.Call("JunkServer.Handler5", JunkArgs{5}, &reply)
+		check("Handler5", ok, reply.X, "no pointer")
+	}
+	{
+		reply := 0
+		ok := e.Call("JunkServer.Handler6", string(make([]byte, 1000)), &reply)
+		check("Handler6", ok, reply, 1000)
+	}
+	{
+		reply := ""
+		ok := e.Call("JunkServer.Handler7", 1000, &reply)
+		check("Handler7", ok, len(reply), 1000)
+	}
+	return results
+}
+
+// * 在不可靠网络上由 nclients 个客户端并发调用 Handler2，
+// * 检查成功的调用回复正确，并报告成功比例。
+func unreliable(rn *labrpc.Network, nclients int, ncalls int) []result {
+	rn.Reliable(false)
+	defer rn.Reliable(true)
+
+	var mu sync.Mutex
+	okCalls, wrong := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < nclients; i++ {
+		endname := "unreliable-" + strconv.Itoa(i)
+		e := rn.MakeEnd(endname)
+		rn.Connect(endname, "server99")
+		rn.Enable(endname, true)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < ncalls; j++ {
+				arg := i*100 + j
+				reply := ""
+				ok := e.Call("JunkServer.Handler2", arg, &reply)
+				mu.Lock()
+				if ok {
+					okCalls += 1
+					if reply != "handler2-"+strconv.Itoa(arg) {
+						wrong += 1
+					}
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := nclients * ncalls
+	return []result{
+		{"replies", wrong == 0, fmt.Sprintf("%v wrong replies", wrong)},
+		{"delivered", okCalls > 0 && okCalls < total,
+			fmt.Sprintf("%v/%v calls succeeded (%.0f%%)", okCalls, total, 100*float64(okCalls)/float64(total))},
+	}
+}
+
+// * 调用会阻塞 20 秒的 Handler3，期间删除服务器，Call 应该很快返回 false。
+func deleted(rn *labrpc.Network) []result {
+	e := rn.MakeEnd("end-slow")
+	rn.Connect("end-slow", "server99")
+	rn.Enable("end-slow", true)
+
+	done := make(chan bool)
+	t0 := time.Now()
+	go func() {
+		reply := 0
+		done <- e.Call("JunkServer.Handler3", 99, &reply)
+	}()
+	time.Sleep(1 * time.Second)
+	rn.DeleteServer("server99")
+
+	select {
+	case ok := <-done:
+		return []result{{"Handler3", !ok, fmt.Sprintf("Call returned %v after %v", ok, time.Since(t0).Round(time.Millisecond))}}
+	case <-time.After(5 * time.Second):
+		return []result{{"Handler3", false, "Call did not return after DeleteServer"}}
+	}
+}
+
+// * 打印检查结果，返回失败的个数。
+func printResults(results []result) int {
+	failed := 0
+	for _, r := range results {
+		status := "ok  "
+		if !r.ok {
+			status = "FAIL"
+			failed += 1
+		}
+		fmt.Printf("  %v %-10v %v\n", status, r.name, r.info)
+	}
+	return failed
+}
+
+func printStats(stats map[string]labrpc.MethodStats) {
+	methods := make([]string, 0, len(stats))
+	for m := range stats {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+
+	fmt.Printf("  %-20v %6v %6v %8v %10v %10v %10v %10v\n",
+		"method", "calls", "drops", "timeouts", "req bytes", "rep bytes", "mean", "p99")
+	for _, m := range methods {
+		ms := stats[m]
+		fmt.Printf("  %-20v %6v %6v %8v %10v %10v %10v %10v\n",
+			m, ms.Calls, ms.Drops, ms.Timeouts, ms.RequestBytes, ms.ReplyBytes,
+			ms.Latency.Mean().Round(time.Microsecond), ms.Latency.Quantile(0.99))
+	}
+}
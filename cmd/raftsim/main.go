@@ -0,0 +1,448 @@
+// raftsim 在 labrpc.Network 上建立一个 N 个节点的集群，从标准输入读取命令来
+// 制造分区、崩溃和重启，并在节点的角色、任期和日志变化时打印出来。
+//
+//	go run ./cmd/raftsim [-n 5] [-seed 42]
+//
+// 命令：
+//
+//	partition 0,1 2,3,4   把节点分成互相不通的组
+//	oneway 2 0            节点 2 发往节点 0 的消息全部丢弃
+//	heal                  清除所有分区
+//	crash 2               让节点 2 崩溃，只保留它已经持久化的状态
+//	restart 2             用持久化的状态重启节点 2
+//	unreliable on|off     随机丢包和延迟
+//	start cmd             把 cmd 交给当前的 leader
+//	status                打印每个节点的角色、任期和日志
+//	stats                 打印网络统计
+//	help, quit
+//
+// 注意：raft 包还没有实现 Make，所以现在这只是一个框架。makePeer 创建的是
+// 不参与共识的占位节点：它们一直是 Follower、任期 0、日志为空，start 总是找不到 leader，
+// 只有分区、崩溃、重启和网络统计是真实的。节点通过 Peer 接口接入，
+// raft.Make 实现后，把 makePeer 换成对它的包装即可。
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/gyy0727/mit-6.824/labrpc"
+	"github.com/gyy0727/mit-6.824/raft"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// *集群中一个节点需要提供的操作，和 Raft 实验的接口一致
+type Peer interface {
+	Start(command interface{}) (int, int, bool) //* 返回 index、term、是否是 leader
+	Status() PeerStatus
+	Kill()
+}
+
+// *节点的状态
+type PeerStatus struct {
+	Role string //* raft.ROLE_LEADER 等
+	Term int
+	Log  []raft.LogEntry
+}
+
+// *创建一个节点，peers[j] 是它到节点 j 的端点，提交的日志写入 applyCh
+var makePeer = func(peers []*labrpc.ClientEnd, me int, persister *raft.Persister, applyCh chan raft.ApplyMsg) Peer {
+	return &stubPeer{}
+}
+
+// *占位节点，不参与共识
+type stubPeer struct{}
+
+func (sp *stubPeer) Start(command interface{}) (int, int, bool) {
+	return -1, 0, false
+}
+
+func (sp *stubPeer) Status() PeerStatus {
+	return PeerStatus{Role: raft.ROLE_FOLLOWER}
+}
+
+func (sp *stubPeer) Kill() {}
+
+// *模拟的集群
+type cluster struct {
+	mu         sync.Mutex
+	out        io.Writer
+	start      time.Time
+	n          int
+	rn         *labrpc.Network
+	peers      []Peer
+	saved      []*raft.Persister
+	applyDone  []chan struct{} //* 每个存活节点的 applier 的退出信号，崩溃时关闭
+	alive      []bool
+	endnames   [][]string
+	last       []PeerStatus //* 上次打印时的状态，用来发现变化
+	generation int          //* 每次启动节点都换一批端点名，旧节点的 RPC 不会送到新节点
+}
+
+func makeCluster(n int, seed int64, out io.Writer) *cluster {
+	cl := &cluster{out: out, start: time.Now(), n: n}
+	if seed != 0 {
+		cl.rn = labrpc.MakeNetworkSeed(seed)
+	} else {
+		cl.rn = labrpc.MakeNetwork()
+	}
+	cl.peers = make([]Peer, n)
+	cl.saved = make([]*raft.Persister, n)
+	cl.applyDone = make([]chan struct{}, n)
+	cl.alive = make([]bool, n)
+	cl.endnames = make([][]string, n)
+	cl.last = make([]PeerStatus, n)
+	for i := 0; i < n; i++ {
+		cl.saved[i] = raft.MakePersister()
+	}
+	for i := 0; i < n; i++ {
+		//* 新的 Persister 是空的，不会校验失败
+		cl.startPeer(i)
+	}
+	return cl
+}
+
+// * 打印一行事件，带上从启动开始的时间。
+func (cl *cluster) printf(format string, a ...interface{}) {
+	fmt.Fprintf(cl.out, "[%7.3fs] %v\n", time.Since(cl.start).Seconds(), fmt.Sprintf(format, a...))
+}
+
+// * 启动或重启节点 i，调用者需持有 cl.mu。
+// * 持久化的状态校验失败时节点拒绝启动，保持崩溃的状态。
+func (cl *cluster) startPeer(i int) error {
+	cl.crashPeer(i)
+
+	//* 新节点只能看到崩溃前已经持久化的状态
+	persister := cl.saved[i].Copy()
+	cl.saved[i] = persister
+	if _, _, err := raft.ReadPersisted(persister); err != nil {
+		return fmt.Errorf("peer %v refuses to start: %w", i, err)
+	}
+
+	cl.generation += 1
+	cl.endnames[i] = make([]string, cl.n)
+	ends := make([]*labrpc.ClientEnd, cl.n)
+	for j := 0; j < cl.n; j++ {
+		cl.endnames[i][j] = fmt.Sprintf("%v-%v-%v", i, j, cl.generation)
+		ends[j] = cl.rn.MakeEnd(cl.endnames[i][j])
+		cl.rn.Connect(cl.endnames[i][j], j)
+		cl.rn.SetEndOwner(cl.endnames[i][j], i)
+	}
+
+	//* 每次启动都用新的 applyCh，旧节点在 Kill 之后仍然可能发送，不能关闭它
+	applyCh := make(chan raft.ApplyMsg)
+	done := make(chan struct{})
+	cl.applyDone[i] = done
+	go cl.applier(i, applyCh, done)
+
+	peer := makePeer(ends, i, persister, applyCh)
+	rs := labrpc.MakeServer()
+	rs.AddService(labrpc.MakeService(peer))
+	cl.rn.AddServer(i, rs)
+
+	cl.peers[i] = peer
+	cl.alive[i] = true
+	cl.last[i] = PeerStatus{}
+	cl.setConnected(i, true)
+	return nil
+}
+
+// * 让节点 i 崩溃，调用者需持有 cl.mu。
+func (cl *cluster) crashPeer(i int) {
+	if !cl.alive[i] {
+		return
+	}
+	cl.setConnected(i, false)
+	cl.rn.DeleteServer(i)
+	//* 崩溃之后节点对旧的 Persister 的写入不再可见
+	cl.saved[i] = cl.saved[i].Copy()
+	cl.peers[i].Kill()
+	cl.peers[i] = nil
+	close(cl.applyDone[i])
+	cl.applyDone[i] = nil
+	cl.alive[i] = false
+}
+
+// * 打开或关闭节点 i 与其他存活节点之间的端点，调用者需持有 cl.mu。
+func (cl *cluster) setConnected(i int, on bool) {
+	for j := 0; j < cl.n; j++ {
+		if j != i && !cl.alive[j] {
+			continue
+		}
+		cl.rn.Enable(cl.endnames[i][j], on)
+		if cl.endnames[j] != nil {
+			cl.rn.Enable(cl.endnames[j][i], on)
+		}
+	}
+}
+
+// * 打印节点 i 提交的日志和快照，节点崩溃（done 被关闭）后退出。
+// * 崩溃之后才读到的消息来自已经被 Kill 的节点，丢弃。
+func (cl *cluster) applier(i int, applyCh chan raft.ApplyMsg, done chan struct{}) {
+	for {
+		var m raft.ApplyMsg
+		select {
+		case <-done:
+			return
+		case m = <-applyCh:
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if m.CommandValid {
+			cl.printf("peer %v applied index %v term %v: %v", i, m.CommandIndex, m.CommandTerm, m.Command)
+		} else {
+			cl.printf("peer %v installed snapshot through index %v term %v", i, m.LastIncludedIndex, m.LastIncludedTerm)
+		}
+	}
+}
+
+// * 定期检查节点状态，打印角色、任期和日志长度的变化。
+func (cl *cluster) watch(done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		cl.check()
+	}
+}
+
+// * 检查一次节点状态，打印和上次相比的变化。
+func (cl *cluster) check() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for i := 0; i < cl.n; i++ {
+		if !cl.alive[i] {
+			continue
+		}
+		st := cl.peers[i].Status()
+		old := cl.last[i]
+		if st.Role != old.Role || st.Term != old.Term {
+			cl.printf("peer %v: %v term %v", i, st.Role, st.Term)
+		}
+		if len(st.Log) != len(old.Log) {
+			cl.printf("peer %v: log %v", i, formatLog(st.Log))
+		}
+		cl.last[i] = st
+	}
+}
+
+// * 只显示最后 10 项日志。
+func formatLog(log []raft.LogEntry) string {
+	parts := []string{}
+	from := 0
+	if len(log) > 10 {
+		from = len(log) - 10
+		parts = append(parts, fmt.Sprintf("...(%v)", from))
+	}
+	for _, e := range log[from:] {
+		parts = append(parts, fmt.Sprintf("%v:%v", e.Term, e.Command))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// * 节点是否是占位节点。
+func (cl *cluster) stub() bool {
+	for _, p := range cl.peers {
+		if _, ok := p.(*stubPeer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// * 解析节点编号。
+func (cl *cluster) parsePeer(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 || i >= cl.n {
+		return 0, fmt.Errorf("bad peer %q, expected 0..%v", s, cl.n-1)
+	}
+	return i, nil
+}
+
+// * 解析逗号分隔的节点列表，例如 "0,1"。
+func (cl *cluster) parseGroup(s string) ([]interface{}, error) {
+	group := []interface{}{}
+	for _, f := range strings.Split(s, ",") {
+		i, err := cl.parsePeer(f)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, i)
+	}
+	return group, nil
+}
+
+// * 执行一条命令，返回 false 表示退出。
+func (cl *cluster) exec(line string) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true, nil
+	}
+	cmd, args := fields[0], fields[1:]
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	switch cmd {
+	case "partition":
+		if len(args) == 0 {
+			return true, fmt.Errorf("usage: partition 0,1 2,3,4")
+		}
+		groups := [][]interface{}{}
+		for _, a := range args {
+			group, err := cl.parseGroup(a)
+			if err != nil {
+				return true, err
+			}
+			groups = append(groups, group)
+		}
+		cl.rn.Partition(groups...)
+		cl.printf("partitioned %v", strings.Join(args, " | "))
+	case "oneway":
+		if len(args) != 2 {
+			return true, fmt.Errorf("usage: oneway FROM TO")
+		}
+		from, err := cl.parseGroup(args[0])
+		if err != nil {
+			return true, err
+		}
+		to, err := cl.parseGroup(args[1])
+		if err != nil {
+			return true, err
+		}
+		cl.rn.PartitionOneWay(from, to)
+		cl.printf("messages from %v to %v are dropped", args[0], args[1])
+	case "heal":
+		cl.rn.Heal()
+		cl.printf("healed all partitions")
+	case "crash", "restart":
+		if len(args) != 1 {
+			return true, fmt.Errorf("usage: %v PEER", cmd)
+		}
+		i, err := cl.parsePeer(args[0])
+		if err != nil {
+			return true, err
+		}
+		if cmd == "crash" {
+			if !cl.alive[i] {
+				return true, fmt.Errorf("peer %v is already down", i)
+			}
+			cl.crashPeer(i)
+			cl.printf("peer %v crashed", i)
+		} else {
+			if err := cl.startPeer(i); err != nil {
+				return true, err
+			}
+			cl.printf("peer %v restarted", i)
+		}
+	case "unreliable":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return true, fmt.Errorf("usage: unreliable on|off")
+		}
+		cl.rn.Reliable(args[0] == "off")
+		cl.printf("unreliable %v", args[0])
+	case "start":
+		if len(args) == 0 {
+			return true, fmt.Errorf("usage: start CMD")
+		}
+		command := strings.Join(args, " ")
+		for i := 0; i < cl.n; i++ {
+			if !cl.alive[i] {
+				continue
+			}
+			if index, term, ok := cl.peers[i].Start(command); ok {
+				cl.printf("peer %v accepted %q at index %v term %v", i, command, index, term)
+				return true, nil
+			}
+		}
+		cl.printf("no leader accepted %q", command)
+	case "status":
+		cl.status()
+	case "stats":
+		cl.stats()
+	case "help":
+		fmt.Fprintf(cl.out, "commands: partition 0,1 2,3,4 | oneway 2 0 | heal | crash N | restart N | unreliable on|off | start CMD | status | stats | quit\n")
+		if cl.stub() {
+			fmt.Fprintf(cl.out, "note: raft.Make is not implemented yet; peers are stubs, so start never finds a leader and status shows Follower, term 0, empty log\n")
+		}
+	case "quit", "exit":
+		return false, nil
+	default:
+		return true, fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return true, nil
+}
+
+// * 打印每个节点的状态，调用者需持有 cl.mu。
+func (cl *cluster) status() {
+	for i := 0; i < cl.n; i++ {
+		if !cl.alive[i] {
+			fmt.Fprintf(cl.out, "  peer %v  down\n", i)
+			continue
+		}
+		st := cl.peers[i].Status()
+		fmt.Fprintf(cl.out, "  peer %v  %-10v term %-4v state %5vB  log %v\n",
+			i, st.Role, st.Term, cl.saved[i].RaftStateSize(), formatLog(st.Log))
+	}
+}
+
+// * 打印网络统计，调用者需持有 cl.mu。
+func (cl *cluster) stats() {
+	fmt.Fprintf(cl.out, "  total: %v RPCs, %v bytes\n", cl.rn.GetTotalCount(), cl.rn.GetTotalBytes())
+	for i := 0; i < cl.n; i++ {
+		stats := cl.rn.ServerMethodStats(i)
+		methods := make([]string, 0, len(stats))
+		for m := range stats {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			ms := stats[m]
+			fmt.Fprintf(cl.out, "  peer %v  %-22v calls %-6v drops %-5v bytes %v/%v  mean %v\n",
+				i, m, ms.Calls, ms.Drops, ms.RequestBytes, ms.ReplyBytes, ms.Latency.Mean().Round(time.Microsecond))
+		}
+	}
+}
+
+func main() {
+	n := flag.Int("n", 5, "节点数")
+	seed := flag.Int64("seed", 0, "故障注入的随机种子，0 表示随机")
+	flag.Parse()
+
+	cl := makeCluster(*n, *seed, os.Stdout)
+	defer cl.rn.Cleanup()
+	cl.printf("started %v peers, seed %v", *n, cl.rn.Seed())
+	if cl.stub() {
+		cl.printf("raft.Make is not implemented yet; peers are stubs that never elect a leader, type help for details")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go cl.watch(done)
+
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stdout, "> ")
+		if !in.Scan() {
+			break
+		}
+		more, err := cl.exec(in.Text())
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "error: %v\n", err)
+		}
+		if !more {
+			break
+		}
+	}
+}
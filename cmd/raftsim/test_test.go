@@ -0,0 +1,158 @@
+package main
+
+import "testing"
+import "bytes"
+import "errors"
+import "strings"
+import "sync"
+import "time"
+import "github.com/gyy0727/mit-6.824/labrpc"
+import "github.com/gyy0727/mit-6.824/raft"
+
+// *可以由测试改变角色和任期的节点
+type fakePeer struct {
+	role    string
+	term    int
+	log     []raft.LogEntry
+	applyCh chan raft.ApplyMsg
+	killed  bool
+}
+
+func (fp *fakePeer) Start(command interface{}) (int, int, bool) {
+	if fp.role != raft.ROLE_LEADER {
+		return -1, fp.term, false
+	}
+	fp.log = append(fp.log, raft.LogEntry{Term: fp.term, Command: command})
+	index := len(fp.log)
+	fp.applyCh <- raft.ApplyMsg{CommandValid: true, Command: command, CommandIndex: index, CommandTerm: fp.term}
+	return index, fp.term, true
+}
+
+func (fp *fakePeer) Status() PeerStatus {
+	return PeerStatus{Role: fp.role, Term: fp.term, Log: fp.log}
+}
+
+func (fp *fakePeer) Kill() {
+	fp.killed = true
+}
+
+// *applier 和命令在不同的 goroutine 中打印，写输出需要加锁
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+// * 取出到目前为止的输出并清空。
+func (sb *syncBuffer) take() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	s := sb.buf.String()
+	sb.buf.Reset()
+	return s
+}
+
+func TestExec(t *testing.T) {
+	var fakes []*fakePeer
+	old := makePeer
+	makePeer = func(peers []*labrpc.ClientEnd, me int, persister *raft.Persister, applyCh chan raft.ApplyMsg) Peer {
+		fp := &fakePeer{role: raft.ROLE_FOLLOWER, applyCh: applyCh}
+		fakes = append(fakes, fp)
+		return fp
+	}
+	defer func() { makePeer = old }()
+
+	out := &syncBuffer{}
+	cl := makeCluster(3, 1, out)
+	defer cl.rn.Cleanup()
+
+	exec := func(line string) {
+		t.Helper()
+		if _, err := cl.exec(line); err != nil {
+			t.Fatalf("%v: %v", line, err)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		got := ""
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+			got += out.take()
+			missing := false
+			for _, w := range want {
+				if !strings.Contains(got, w) {
+					missing = true
+				}
+			}
+			if !missing {
+				return
+			}
+		}
+		t.Fatalf("expected %q in output:\n%v", want, got)
+	}
+
+	cl.check()
+	expect("peer 0: Follower term 0", "peer 2: Follower term 0")
+
+	fakes[0].role, fakes[0].term = raft.ROLE_LEADER, 1
+	cl.check()
+	expect("peer 0: Leader term 1")
+
+	exec("start put x")
+	cl.check()
+	expect(`peer 0 accepted "put x" at index 1 term 1`, "peer 0 applied index 1 term 1: put x", "peer 0: log [1:put x]")
+
+	exec("partition 0 1,2")
+	expect("partitioned 0 | 1,2")
+
+	exec("crash 0")
+	expect("peer 0 crashed")
+	if !fakes[0].killed {
+		t.Fatalf("crashed peer was not killed")
+	}
+	//* 被 Kill 的节点仍然可以发送，不会 panic，发送的消息被丢弃
+	select {
+	case fakes[0].applyCh <- raft.ApplyMsg{CommandValid: true, Command: "late", CommandIndex: 99}:
+	case <-time.After(100 * time.Millisecond):
+	}
+	time.Sleep(50 * time.Millisecond)
+	if strings.Contains(out.take(), "late") {
+		t.Fatalf("message from a crashed peer was printed")
+	}
+
+	fakes[1].role, fakes[1].term = raft.ROLE_LEADER, 2
+	cl.check()
+	expect("peer 1: Leader term 2")
+
+	exec("heal")
+	exec("restart 0")
+	if len(fakes) != 4 {
+		t.Fatalf("restart should create a new peer, have %v", len(fakes))
+	}
+	cl.check()
+	expect("peer 0 restarted", "peer 0: Follower term 0")
+
+	exec("crash 2")
+	exec("status")
+	expect("peer 0  Follower", "peer 1  Leader     term 2", "peer 2  down")
+
+	if _, err := cl.exec("crash 2"); err == nil {
+		t.Fatalf("crashing a down peer should fail")
+	}
+
+	//* 持久化的状态损坏时节点拒绝启动
+	fp, _ := raft.MakeFaultyPersister(nil)
+	fp.SaveRaftState([]byte("state"))
+	cl.saved[2] = fp.Crash(raft.CrashCorrupt)
+	if _, err := cl.exec("restart 2"); !errors.Is(err, raft.ErrCorrupt) || cl.alive[2] {
+		t.Fatalf("peer with corrupt state should refuse to start: %v", err)
+	}
+
+	if more, _ := cl.exec("quit"); more {
+		t.Fatalf("quit should stop the loop")
+	}
+}
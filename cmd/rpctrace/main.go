@@ -0,0 +1,117 @@
+// rpctrace 把 labrpc.JSONTracer 写出的 JSONL 跟踪文件按节点渲染成时间线。
+//
+//	go run ./cmd/rpctrace [-peer 2] [-method Raft.AppendEntries] [-args] trace.jsonl
+//
+// 每个节点的时间线里：
+//
+//	-> 请求从本节点发出    <- 回复回到本节点
+//	=> 请求到达本节点      <= 回复从本节点发出
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/gyy0727/mit-6.824/labrpc"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// *时间线上的一行
+type line struct {
+	at    time.Time
+	arrow string
+	peer  string //* 对端
+	ev    *labrpc.TraceEvent
+}
+
+func main() {
+	peer := flag.String("peer", "", "只显示这个节点的时间线")
+	method := flag.String("method", "", "只显示这个 RPC 方法，例如 Raft.AppendEntries")
+	showArgs := flag.Bool("args", false, "显示请求参数")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("rpctrace: %v\n", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	events, err := readEvents(in)
+	if err != nil {
+		log.Fatalf("rpctrace: %v\n", err)
+	}
+
+	timelines := map[string][]line{}
+	var start time.Time
+	for _, ev := range events {
+		if *method != "" && ev.SvcMeth != *method {
+			continue
+		}
+		if start.IsZero() || ev.Time.Before(start) {
+			start = ev.Time
+		}
+		if ev.Kind == "request" {
+			timelines[ev.From] = append(timelines[ev.From], line{ev.Time, "->", ev.Server, ev})
+			if ev.Decision == labrpc.TraceDelivered || ev.Decision == labrpc.TraceDuplicated {
+				timelines[ev.Server] = append(timelines[ev.Server], line{ev.Time.Add(ev.Delay), "=>", ev.From, ev})
+			}
+		} else {
+			timelines[ev.Server] = append(timelines[ev.Server], line{ev.Time, "<=", ev.From, ev})
+			if ev.Decision == labrpc.TraceDelivered || ev.Decision == labrpc.TraceDelayed {
+				timelines[ev.From] = append(timelines[ev.From], line{ev.Time.Add(ev.Delay), "<-", ev.Server, ev})
+			}
+		}
+	}
+
+	peers := []string{}
+	for p := range timelines {
+		if *peer == "" || p == *peer {
+			peers = append(peers, p)
+		}
+	}
+	sort.Strings(peers)
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, p := range peers {
+		lines := timelines[p]
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].at.Before(lines[j].at) })
+		fmt.Fprintf(w, "== %v ==\n", p)
+		for _, l := range lines {
+			ms := float64(l.at.Sub(start)) / float64(time.Millisecond)
+			fmt.Fprintf(w, "%10.3fms  %v %-4v %-24v #%-5v %6vB  %v",
+				ms, l.arrow, l.peer, l.ev.SvcMeth, l.ev.Seq, l.ev.Bytes, l.ev.Decision)
+			if l.ev.Delay > 0 && (l.arrow == "->" || l.arrow == "<=") {
+				fmt.Fprintf(w, " (+%v)", l.ev.Delay)
+			}
+			if *showArgs && len(l.ev.Args) > 0 && l.arrow == "->" {
+				fmt.Fprintf(w, " %s", l.ev.Args)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// * 逐行读取跟踪记录。
+func readEvents(r io.Reader) ([]*labrpc.TraceEvent, error) {
+	events := []*labrpc.TraceEvent{}
+	dec := json.NewDecoder(r)
+	for {
+		ev := &labrpc.TraceEvent{}
+		if err := dec.Decode(ev); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+}
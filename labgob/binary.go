@@ -0,0 +1,406 @@
+package labgob
+
+//
+// Binary 编码的实现。
+//
+//	bool              1 字节
+//	int*              zigzag varint
+//	uint*             varint
+//	float32, float64  4 或 8 字节小端 IEEE 754
+//	string            varint 长度 + 内容
+//	slice, map        varint 元素个数加一（0 表示 nil）+ 元素（map 为键值对，[]byte 为内容）
+//	array             各个元素
+//	struct            各个导出字段，按声明顺序
+//	pointer           1 字节（0 表示 nil）+ 指向的值
+//	interface         类型名（同 string，空串表示 nil）+ 值，类型需要用 Register 注册
+//
+// 同时实现了 encoding.BinaryMarshaler 和 encoding.BinaryUnmarshaler 的非指针类型
+// （例如 time.Time，方法的接收者是值或指针都可以）编码为 MarshalBinary 的结果，见 usesMarshaler。
+//
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+)
+
+var errShort = errors.New("labgob: binary value too short")
+
+// *编码后不占字节的元素（例如 struct{}）的切片最多有多少个元素，防止损坏的长度导致死循环
+const maxZeroSizeElems = 1 << 20
+
+// *类型编码后的最小字节数的缓存，reflect.Type -> int
+var minSizes sync.Map
+
+var (
+	marshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// * 类型 t 是否编码为 MarshalBinary 的结果，编码和解码都用它判断。
+// * 指针和接口按普通规则编码，它们指向的值再按这个规则判断。
+func usesMarshaler(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface || t.Kind() == reflect.Ptr {
+		return false
+	}
+	pt := reflect.PointerTo(t)
+	return pt.Implements(marshalerType) && pt.Implements(unmarshalerType)
+}
+
+type binaryEncoder struct {
+	buf []byte
+}
+
+func (e *binaryEncoder) uvarint(x uint64) {
+	e.buf = binary.AppendUvarint(e.buf, x)
+}
+
+func (e *binaryEncoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// * 切片和 map 的长度，nil 编码为 0，其他编码为长度加一。
+func (e *binaryEncoder) count(v reflect.Value) {
+	if v.IsNil() {
+		e.uvarint(0)
+	} else {
+		e.uvarint(uint64(v.Len()) + 1)
+	}
+}
+
+func (e *binaryEncoder) encode(v reflect.Value) error {
+	t := v.Type()
+	if usesMarshaler(t) {
+		m, ok := v.Interface().(encoding.BinaryMarshaler)
+		if !ok {
+			//* MarshalBinary 的接收者是指针，v 不一定可以取地址，先拷贝一份
+			p := reflect.New(t)
+			p.Elem().Set(v)
+			m = p.Interface().(encoding.BinaryMarshaler)
+		}
+		b, err := m.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.bytes(b)
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.buf = binary.AppendVarint(e.buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uvarint(v.Uint())
+	case reflect.Float32:
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.uvarint(uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice:
+		e.count(v)
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		e.count(v)
+		it := v.MapRange()
+		for it.Next() {
+			if err := e.encode(it.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(it.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				if err := e.encode(v.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0)
+			return nil
+		}
+		e.buf = append(e.buf, 1)
+		return e.encode(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			e.uvarint(0)
+			return nil
+		}
+		name, ok := lookupName(v.Elem().Type())
+		if !ok {
+			return fmt.Errorf("%w: %v", errUnregistered, typeName(v.Elem().Type()))
+		}
+		e.uvarint(uint64(len(name)))
+		e.buf = append(e.buf, name...)
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("labgob: cannot encode %v with the binary codec", t)
+	}
+	return nil
+}
+
+type binaryDecoder struct {
+	data []byte
+}
+
+func (d *binaryDecoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, errShort
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+func (d *binaryDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)) {
+		return nil, errShort
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// * 读出切片或 map 的长度，nil 时 isNil 为 true。
+// * 长度不能超过剩下的数据能容纳的元素个数，防止损坏的长度导致巨大的分配。
+// * size 是每个元素编码后至少占的字节数。
+func (d *binaryDecoder) count(size int) (n int, isNil bool, err error) {
+	x, err := d.uvarint()
+	if err != nil {
+		return 0, false, err
+	}
+	if x == 0 {
+		return 0, true, nil
+	}
+	x -= 1
+	if size > 0 {
+		if x > uint64(len(d.data)/size) {
+			return 0, false, errShort
+		}
+	} else if x > maxZeroSizeElems {
+		return 0, false, fmt.Errorf("labgob: %v zero-size elements", x)
+	}
+	return int(x), false, nil
+}
+
+// * 类型 t 编码后至少占多少字节，和 encode 对应。
+// * 没有导出字段的结构体和长度为 0 的数组编码后不占字节。
+func minEncodedSize(t reflect.Type) int {
+	if n, ok := minSizes.Load(t); ok {
+		return n.(int)
+	}
+	n := 1 //* 其他类型至少有一个字节的长度、标记或 varint
+	switch {
+	case usesMarshaler(t):
+	case t.Kind() == reflect.Float32:
+		n = 4
+	case t.Kind() == reflect.Float64:
+		n = 8
+	case t.Kind() == reflect.Array:
+		n = t.Len() * minEncodedSize(t.Elem())
+	case t.Kind() == reflect.Struct:
+		n = 0
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				n += minEncodedSize(t.Field(i).Type)
+			}
+		}
+	}
+	minSizes.Store(t, n)
+	return n
+}
+
+func (d *binaryDecoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+// * 解码到 v，v 必须是可以赋值的。
+func (d *binaryDecoder) decode(v reflect.Value) error {
+	t := v.Type()
+	if usesMarshaler(t) {
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, n := binary.Varint(d.data)
+		if n <= 0 {
+			return errShort
+		}
+		d.data = d.data[n:]
+		if v.OverflowInt(x) {
+			return fmt.Errorf("labgob: value %v overflows %v", x, t)
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(x) {
+			return fmt.Errorf("labgob: value %v overflows %v", x, t)
+		}
+		v.SetUint(x)
+	case reflect.Float32:
+		b, err := d.next(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case reflect.Float64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, isNil, err := d.count(minEncodedSize(t.Elem()))
+		if err != nil {
+			return err
+		}
+		if isNil {
+			v.SetZero()
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			b, err := d.next(uint64(n))
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		s := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, isNil, err := d.count(minEncodedSize(t.Key()) + minEncodedSize(t.Elem()))
+		if err != nil {
+			return err
+		}
+		if isNil {
+			v.SetZero()
+			return nil
+		}
+		m := reflect.MakeMapWithSize(t, n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.decode(elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				if err := d.decode(v.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Ptr:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.SetZero()
+			return nil
+		}
+		p := reflect.New(t.Elem())
+		if err := d.decode(p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.Interface:
+		name, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		if len(name) == 0 {
+			v.SetZero()
+			return nil
+		}
+		et, ok := lookupType(string(name))
+		if !ok {
+			return fmt.Errorf("labgob: name not registered for interface: %q", name)
+		}
+		if !et.Implements(t) {
+			return fmt.Errorf("labgob: %v does not implement %v", et, t)
+		}
+		ev := reflect.New(et).Elem()
+		if err := d.decode(ev); err != nil {
+			return err
+		}
+		v.Set(ev)
+	default:
+		return fmt.Errorf("labgob: cannot decode %v with the binary codec", t)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package labgob
+
+//
+// 可替换的编码方式。
+// labrpc 通过 Codec 编码参数和回复，持久化的状态可以用 MarshalVersioned 选择 Codec：
+//
+//	Gob     默认，encoding/gob 加上 labgob 的检查
+//	JSON    便于调试，接口类型的字段解码后是 map/float64 等通用值
+//	Binary  手写的紧凑二进制编码，类似 protobuf：整数用 varint，
+//	        结构体按字段顺序编码，不带字段名，所以两端必须使用相同的类型
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// *一种编码方式，实现必须可以被并发使用
+type Codec interface {
+	//* 编码方式的名字，例如 "gob"，用于在 TCP 帧中标识编码方式
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	//* v 必须是指针
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	Gob    Codec = gobCodec{}
+	JSON   Codec = jsonCodec{}
+	Binary Codec = binaryCodec{}
+)
+
+var codecMu sync.Mutex
+var codecs = map[string]Codec{"gob": Gob, "json": JSON, "binary": Binary}
+
+// * RegisterCodec 注册一种编码方式，之后可以用 CodecByName 按名字找到它。
+func RegisterCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// * CodecByName 按名字查找编码方式，找不到时返回 nil。
+func CodecByName(name string) Codec {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	return codecs[name]
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	return Marshal(v)
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, fmt.Errorf("labgob: cannot encode nil value")
+	}
+	//* 和 gob 一样，最外层的指针不编码
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("labgob: cannot encode nil pointer of type %v", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+	e := &binaryEncoder{}
+	if err := e.encode(rv); err != nil {
+		return nil, explainEncodeError(v, err)
+	}
+	return e.buf, nil
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("labgob: Unmarshal into non-pointer %T", v)
+	}
+	rv = rv.Elem()
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	d := &binaryDecoder{data: data}
+	if err := d.decode(rv); err != nil {
+		return err
+	}
+	if len(d.data) != 0 {
+		return fmt.Errorf("labgob: %v trailing bytes after binary value", len(d.data))
+	}
+	return nil
+}
@@ -10,14 +10,134 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
 	"sync"
 	"unicode"
 	"unicode/utf8"
 )
 
-var mu sync.Mutex                 //*互斥锁
-var errorCount int                // 用于 TestCapital
-var checked map[reflect.Type]bool //标记某个类型是否已经被处理
+var mu sync.Mutex                         //*互斥锁
+var errorCount int                        // 用于 TestCapital
+var checked map[reflect.Type][]Diagnostic //已经检查过的类型，以及类型中未大写的字段
+var checkMu sync.Mutex                    //*同一时间只允许一个 goroutine 检查类型
+var strict bool                           //*严格模式
+var deep bool                             //*逐个检查切片和数组的所有元素
+var diagnostics []Diagnostic              //*累积的诊断信息，相同的问题只记录一条
+
+// *诊断信息的种类
+const (
+	DiagLowerCase  = "lower-case"  //* 未大写的字段，gob 会忽略它
+	DiagNonDefault = "non-default" //* 解码到已经有非默认值的变量中，gob 不会覆盖为默认值
+)
+
+// *labgob 发现的一个问题
+type Diagnostic struct {
+	Kind  string //* DiagLowerCase 或 DiagNonDefault
+	Type  string //* Path 所在的类型；CheckError 中是被编码或解码的值的类型
+	Path  string //* 出问题的字段在 Type 中的路径，例如 "Entries[].command"；为空表示值本身
+	Count int    //* 出现的次数，只在 Diagnostics 的结果中有意义
+}
+
+func (d Diagnostic) String() string {
+	what := d.Path
+	if what == "" {
+		what = d.Type
+	}
+	switch d.Kind {
+	case DiagLowerCase:
+		return fmt.Sprintf("lower-case field %v of %v", what, d.Type)
+	case DiagNonDefault:
+		return fmt.Sprintf("decoding into non-default field %v of %v", what, d.Type)
+	}
+	return fmt.Sprintf("%v %v of %v", d.Kind, what, d.Type)
+}
+
+// *严格模式下 Encode/Decode 发现问题时返回的错误
+type CheckError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *CheckError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.String()
+	}
+	return "labgob: " + strings.Join(msgs, "; ")
+}
+
+// * SetStrict 打开或关闭严格模式。
+// * 严格模式下不再向标准输出打印警告，而是由 Encode/Decode 返回 *CheckError，
+// * 并且不进行编码或解码。无论是否严格，发现的问题都会记录到 Diagnostics 中。
+func SetStrict(on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	strict = on
+}
+
+// * SetDeepCheck 打开或关闭完整检查。
+// * 默认每个切片和数组只检查第一个元素，解码的开销和元素个数无关；
+// * 打开完整检查或严格模式后逐个检查所有元素，用于调试。
+func SetDeepCheck(on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	deep = on
+}
+
+// * Diagnostics 返回到目前为止发现的所有问题，按第一次出现的顺序。
+func Diagnostics() []Diagnostic {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Diagnostic{}, diagnostics...)
+}
+
+// * ResetDiagnostics 清空累积的诊断信息。
+func ResetDiagnostics() {
+	mu.Lock()
+	defer mu.Unlock()
+	diagnostics = nil
+}
+
+// * 记录一条诊断信息，调用者需持有 mu。
+func record(d Diagnostic) {
+	errorCount += 1
+	if i := find(d); i >= 0 {
+		diagnostics[i].Count += 1
+		return
+	}
+	d.Count = 1
+	diagnostics = append(diagnostics, d)
+}
+
+// * 已记录的相同问题的下标，没有时返回 -1，调用者需持有 mu。
+func find(d Diagnostic) int {
+	for i := range diagnostics {
+		if diagnostics[i].Kind == d.Kind && diagnostics[i].Type == d.Type && diagnostics[i].Path == d.Path {
+			return i
+		}
+	}
+	return -1
+}
+
+// * DiagnosticsByType 按 Diagnostic.Type 分组返回诊断信息，即每个类型的报告。
+func DiagnosticsByType() map[string][]Diagnostic {
+	res := map[string][]Diagnostic{}
+	for _, d := range Diagnostics() {
+		res[d.Type] = append(res[d.Type], d)
+	}
+	return res
+}
+
+func isStrict() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return strict
+}
+
+func isDeep() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return strict || deep
+}
 
 // * LabEncoder 是对 Go 的 gob.Encoder 的包装，并添加了对未大写字段名的检查。
 type LabEncoder struct {
@@ -32,15 +152,21 @@ func NewEncoder(w io.Writer) *LabEncoder {
 }
 
 // * Encode 使用包装的 gob 编码器对给定的值进行编码，并在编码前检查未大写的字段。
+// * 严格模式下，有未大写的字段时返回 *CheckError。
+// * 接口字段里的具体类型没有注册时返回 *UnregisteredError。
 func (enc *LabEncoder) Encode(e interface{}) error {
-	checkValue(e)
-	return enc.gob.Encode(e)
+	if ds := checkValue(e); len(ds) > 0 && isStrict() {
+		return &CheckError{ds}
+	}
+	return explainEncodeError(e, enc.gob.Encode(e))
 }
 
 // * EncodeValue 使用包装的 gob 编码器对给定的 reflect.Value 进行编码，并在编码前检查未大写的字段。
 func (enc *LabEncoder) EncodeValue(value reflect.Value) error {
-	checkValue(value.Interface())
-	return enc.gob.EncodeValue(value)
+	if ds := checkValue(value.Interface()); len(ds) > 0 && isStrict() {
+		return &CheckError{ds}
+	}
+	return explainEncodeError(value.Interface(), enc.gob.EncodeValue(value))
 }
 
 // * LabDecoder 是对 Go 的 gob.Decoder 的包装，并添加了对未大写字段名的检查。
@@ -56,9 +182,13 @@ func NewDecoder(r io.Reader) *LabDecoder {
 }
 
 // * Decode 从读取器中解码数据到给定的值，并检查未大写字段。
+// * 严格模式下，有未大写的字段或 e 中有非默认值时返回 *CheckError。
 func (dec *LabDecoder) Decode(e interface{}) error {
-	checkValue(e)
-	checkDefault(e)
+	ds := checkValue(e)
+	ds = append(ds, checkDefault(e)...)
+	if len(ds) > 0 && isStrict() {
+		return &CheckError{ds}
+	}
 	return dec.gob.Decode(e)
 }
 
@@ -66,37 +196,68 @@ func (dec *LabDecoder) Decode(e interface{}) error {
 func Register(value interface{}) {
 	checkValue(value)
 	gob.Register(value)
+	registerType(typeName(reflect.TypeOf(value)), reflect.TypeOf(value), true)
 }
 
 // * RegisterName 使用自定义名称将一个值注册到 Go 的 gob 包中，并检查未大写的字段。
 func RegisterName(name string, value interface{}) {
 	checkValue(value)
 	gob.RegisterName(name, value)
+	registerType(name, reflect.TypeOf(value), true)
 }
 
-// * checkValue 检查提供的值的类型，确保它不包含未大写的字段。
-func checkValue(value interface{}) {
-	checkType(reflect.TypeOf(value))
+// * checkValue 检查提供的值的类型，返回类型中未大写的字段。
+func checkValue(value interface{}) []Diagnostic {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		return nil
+	}
+	ds := checkType(t)
+	for i := range ds {
+		ds[i].Type = t.String()
+	}
+	return ds
 }
 
-// * checkType 检查类型及其字段，确保它不包含未大写的字段名。
-func checkType(t reflect.Type) {
-	k := t.Kind() //*t的类型
+// * checkType 检查类型及其字段，返回未大写的字段，路径相对于 t。
+// * 每个类型只在第一次检查时打印和记录，之后直接返回缓存的结果。
+// * 同一时间只有一个 goroutine 在检查，其他 goroutine 等它检查完再读缓存，
+// * 不会看到检查到一半的结果。
+func checkType(t reflect.Type) []Diagnostic {
+	mu.Lock()
+	ds, ok := checked[t]
+	mu.Unlock()
+	if ok {
+		return append([]Diagnostic{}, ds...)
+	}
+
+	checkMu.Lock()
+	defer checkMu.Unlock()
+	return checkType1(t, map[reflect.Type]bool{})
+}
+
+// * 检查类型 t，调用者需持有 checkMu。
+// * active 是正在检查的类型，遇到递归类型时不再深入，避免死循环。
+func checkType1(t reflect.Type, active map[reflect.Type]bool) []Diagnostic {
 	mu.Lock()
-	// 只检测一次，并避免递归。
 	if checked == nil {
-		checked = map[reflect.Type]bool{}
+		checked = map[reflect.Type][]Diagnostic{}
 	}
 	//*类型已经被检查过
-	if checked[t] {
+	if ds, ok := checked[t]; ok {
 		mu.Unlock()
-		//*直接返回,因为函数目的就是检查类型有没有未大写的字段
-		return
+		return append([]Diagnostic{}, ds...)
 	}
-	//*标记当前类型正在被检查
-	checked[t] = true
-	//*操作完共享数据了,直接解锁
 	mu.Unlock()
+	//*类型正在被检查（递归类型）
+	if active[t] {
+		return nil
+	}
+	active[t] = true
+	defer delete(active, t)
+
+	k := t.Kind() //*t的类型
+	var ds []Diagnostic
 	switch k {
 	case reflect.Struct:
 		//* 如果是结构体类型，检查所有字段的名字。
@@ -104,46 +265,100 @@ func checkType(t reflect.Type) {
 			f := t.Field(i)
 			rune, _ := utf8.DecodeRuneInString(f.Name)
 			if unicode.IsUpper(rune) == false {
-				// 如果字段名是小写的，输出错误信息
-				fmt.Printf("labgob error: lower-case field %v of %v in RPC or persist/snapshot will break your Raft\n",
-					f.Name, t.Name())
+				d := Diagnostic{Kind: DiagLowerCase, Type: t.String(), Path: f.Name}
 				mu.Lock()
-				errorCount += 1
+				if strict == false {
+					// 如果字段名是小写的，输出错误信息
+					fmt.Printf("labgob error: lower-case field %v of %v in RPC or persist/snapshot will break your Raft\n",
+						f.Name, t.Name())
+				}
+				record(d)
 				mu.Unlock()
+				ds = append(ds, d)
 			}
 			// 递归检查字段的类型
-			checkType(f.Type)
+			ds = append(ds, prefix(checkType1(f.Type, active), f.Name)...)
 		}
-		return
-	case reflect.Slice, reflect.Array, reflect.Ptr:
-		//* 如果是切片、数组或指针，递归检查元素类型。
-		checkType(t.Elem())
-		return
+	case reflect.Slice, reflect.Array:
+		//* 如果是切片或数组，递归检查元素类型。
+		ds = prefix(checkType1(t.Elem(), active), "[]")
+	case reflect.Ptr:
+		//* 如果是指针，递归检查指向的类型。
+		ds = checkType1(t.Elem(), active)
 	case reflect.Map:
 		//* 如果是映射，递归检查键和值的类型。
-		checkType(t.Elem())
-		checkType(t.Key())
-		return
+		ds = append(prefix(checkType1(t.Elem(), active), "[]"), prefix(checkType1(t.Key(), active), "[key]")...)
 	default:
 		//* 默认情况下，不需要做任何检查。
-		return
 	}
+
+	mu.Lock()
+	checked[t] = ds
+	mu.Unlock()
+	return append([]Diagnostic{}, ds...)
+}
+
+// * 给诊断信息的路径加上前缀 p。
+func prefix(ds []Diagnostic, p string) []Diagnostic {
+	for i := range ds {
+		switch {
+		case ds[i].Path == "":
+			ds[i].Path = p
+		case strings.HasPrefix(ds[i].Path, "["):
+			ds[i].Path = p + ds[i].Path
+		default:
+			ds[i].Path = p + "." + ds[i].Path
+		}
+	}
+	return ds
 }
 
 // * checkDefault 检查值是否包含非默认值，如果包含，可能会导致 RPC 或持久化/快照问题。
-func checkDefault(value interface{}) {
+// * 重复使用的回复结构体最容易出这种问题：gob 不会把字段覆盖为零值，
+// * 会复用切片原有的元素，也会保留 map 中原有的键。
+func checkDefault(value interface{}) []Diagnostic {
 	if value == nil {
-		return
+		return nil
+	}
+	dc := defaultChecker{top: reflect.TypeOf(value).String(), all: isDeep()}
+	//* 解码的目标总是指针，直接从它指向的值开始，记在 root 里而不是 visited 里，
+	//* 这样没有其他指针、切片或非默认值时不分配内存
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		dc.root = visit{v.Pointer(), v.Type()}
+		v = v.Elem()
 	}
-	checkDefault1(reflect.ValueOf(value), 1, "")
+	dc.check(v, "")
+	return dc.ds
 }
 
-// * checkDefault1 递归检查值及其字段，判断是否包含非默认值。
-func checkDefault1(value reflect.Value, depth int, name string) {
-	if depth > 3 {
-		return
+// *已经检查过的指针、切片或 map，用于防止循环
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+type defaultChecker struct {
+	top     string          //* 被解码的值的类型
+	root    visit           //* 被解码的指针本身
+	visited map[visit]bool  //* 已经访问过的其他引用，第一次进入指针或切片时才创建
+	seen    map[string]bool //* 已经报告过的路径，第一次报告时才创建
+	all     bool            //* 检查切片和数组的所有元素，否则只检查第一个
+	ds      []Diagnostic
+}
+
+// * 要检查的元素个数。
+func (dc *defaultChecker) elems(value reflect.Value) int {
+	if dc.all || value.Len() == 0 {
+		return value.Len()
 	}
+	return 1
+}
 
+// * 递归检查值及其字段、元素，判断是否包含非默认值，没有深度限制。
+// * 切片元素的路径写成 name[]，同一个切片中的元素只报告一次。
+// * map 只按是否为空判断，不检查其中的元素。
+func (dc *defaultChecker) check(value reflect.Value, name string) {
 	t := value.Type()
 	k := t.Kind()
 
@@ -156,36 +371,77 @@ func checkDefault1(value reflect.Value, depth int, name string) {
 			if name != "" {
 				name1 = name + "." + name1
 			}
-			checkDefault1(vv, depth+1, name1)
+			dc.check(vv, name1)
 		}
-		return
 	case reflect.Ptr:
 		//* 如果是指针，检查指针指向的值。
-		if value.IsNil() {
+		if value.IsNil() || dc.enter(value) == false {
 			return
 		}
-		checkDefault1(value.Elem(), depth+1, name)
-		return
+		dc.check(value.Elem(), name)
+	case reflect.Slice:
+		//* gob 解码到已有的切片时会复用其中的元素。
+		if value.Len() == 0 || dc.enter(value) == false {
+			return
+		}
+		for i := 0; i < dc.elems(value); i++ {
+			dc.check(value.Index(i), name+"[]")
+		}
+	case reflect.Array:
+		for i := 0; i < dc.elems(value); i++ {
+			dc.check(value.Index(i), name+"[]")
+		}
+	case reflect.Map:
+		//* gob 解码到已有的 map 时不会删除原有的键。
+		if value.Len() > 0 {
+			dc.report(name, t)
+		}
 	case reflect.Bool,
 		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
 		reflect.Uintptr, reflect.Float32, reflect.Float64,
 		reflect.String:
 		//* 如果是基本类型，检查是否与默认值相等。
-		if reflect.DeepEqual(reflect.Zero(t).Interface(), value.Interface()) == false {
-			mu.Lock()
-			if errorCount < 1 {
-				what := name
-				if what == "" {
-					what = t.Name()
-				}
-				// 输出警告信息
-				fmt.Printf("labgob warning: Decoding into a non-default variable/field %v may not work\n",
-					what)
-			}
-			errorCount += 1
-			mu.Unlock()
+		if value.IsZero() == false {
+			dc.report(name, t)
 		}
+	}
+}
+
+// * 第一次访问指针、切片指向的内存时返回 true。
+func (dc *defaultChecker) enter(value reflect.Value) bool {
+	v := visit{value.Pointer(), value.Type()}
+	if v == dc.root || dc.visited[v] {
+		return false
+	}
+	if dc.visited == nil {
+		dc.visited = map[visit]bool{}
+	}
+	dc.visited[v] = true
+	return true
+}
+
+// * 报告路径 name 处的非默认值，t 是该处的类型。
+func (dc *defaultChecker) report(name string, t reflect.Type) {
+	if dc.seen[name] {
 		return
 	}
+	if dc.seen == nil {
+		dc.seen = map[string]bool{}
+	}
+	dc.seen[name] = true
+	d := Diagnostic{Kind: DiagNonDefault, Type: dc.top, Path: name}
+	mu.Lock()
+	if strict == false && find(d) < 0 {
+		what := name
+		if what == "" {
+			what = t.Name()
+		}
+		// 输出警告信息，每个类型的每个字段只输出一次
+		fmt.Printf("labgob warning: Decoding into a non-default variable/field %v of %v may not work\n",
+			what, dc.top)
+	}
+	record(d)
+	mu.Unlock()
+	dc.ds = append(dc.ds, d)
 }
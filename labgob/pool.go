@@ -0,0 +1,56 @@
+package labgob
+
+//
+// 热路径上的编码。
+// 这里只复用缓冲区：Marshal 从池中取 bytes.Buffer，编码后拷贝出结果再放回。
+//
+// LabEncoder/LabDecoder 本身不放进池里。gob.Encoder 不能重置，
+// 而且一个用过的 Encoder 只在第一次遇到某个接口字段的具体类型时才发送它的描述，
+// 之后编码出的消息依赖这个 Encoder 的历史，只有见过同样历史的 Decoder 才能解码。
+// labrpc 的模拟网络会丢弃、重排和重复消息，每条消息必须能单独解码，
+// 所以 Call 和 dispatch 每条消息仍然新建 Encoder 和 Decoder。
+//
+// 在连接这样的长期存在、按顺序送达的流上，可以让一个 LabEncoder/LabDecoder
+// 对应整条连接，类型描述只发送一次。labrpc 的 TCP 传输层就是这样做的：
+// 编码方式是 gob 时，请求和回复的帧头以及其中的参数和回复都在连接的流上编码；
+// 其他 Codec 的参数和回复仍然是帧里单独编码的消息。
+//
+
+import (
+	"bytes"
+	"sync"
+)
+
+// *超过这个大小的缓冲区不放回池中，避免偶尔的大消息长期占用内存
+const maxPooledBuffer = 1 << 20
+
+var bufPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// * GetBuffer 从池中取一个空的缓冲区，用完后用 PutBuffer 放回。
+func GetBuffer() *bytes.Buffer {
+	return bufPool.Get().(*bytes.Buffer)
+}
+
+// * PutBuffer 把缓冲区放回池中，之后不能再使用 b。
+func PutBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufPool.Put(b)
+}
+
+// * Marshal 用 gob 编码 v，使用池中的缓冲区，返回的切片归调用者所有。
+func Marshal(v interface{}) ([]byte, error) {
+	w := GetBuffer()
+	defer PutBuffer(w)
+	if err := NewEncoder(w).Encode(v); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), w.Bytes()...), nil
+}
+
+// * Unmarshal 用 gob 把 data 解码到 v。
+func Unmarshal(data []byte, v interface{}) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(v)
+}
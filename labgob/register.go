@@ -0,0 +1,229 @@
+package labgob
+
+//
+// 注册过的类型。
+// 接口类型的字段（例如 LogEntry.Command）里的具体类型必须先用 Register 注册，
+// 否则编码时才会失败。这里记录所有注册过的类型，
+// 编码失败时给出没有注册的具体类型和它在值中的位置，
+// 服务也可以在启动时用 MustBeRegistered 检查自己的命令类型。
+// 直接调用 gob.Register 注册的类型不在这张表里：gob 编码时仍然能用，
+// IsRegistered 和编码失败时的定位会去问 gob，但 Registered 不会列出它们，
+// Binary 编码也不认识它们。
+//
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var typeMu sync.Mutex
+var typeByName = map[string]reflect.Type{}
+var nameByType = map[reflect.Type]string{}
+var userTypes = map[string]bool{} //* 通过 Register/RegisterName 注册的类型名
+
+func init() {
+	//* 和 gob 的 registerBasics 预先注册同样的基本类型，它们可以直接放进接口里
+	for _, v := range []interface{}{
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0), complex64(0i), complex128(0i),
+		uintptr(0), false, "",
+		[]byte(nil), []int(nil), []int8(nil), []int16(nil), []int32(nil), []int64(nil),
+		[]uint(nil), []uint16(nil), []uint32(nil), []uint64(nil),
+		[]float32(nil), []float64(nil), []complex64(nil), []complex128(nil),
+		[]uintptr(nil), []bool(nil), []string(nil),
+	} {
+		registerType(typeName(reflect.TypeOf(v)), reflect.TypeOf(v), false)
+	}
+}
+
+// * 类型的默认名字：命名类型带上包路径，指针前加 "*"。
+func typeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		return "*" + typeName(t.Elem())
+	}
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+func registerType(name string, t reflect.Type, user bool) {
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	typeByName[name] = t
+	nameByType[t] = name
+	if user {
+		userTypes[name] = true
+	}
+}
+
+func lookupName(t reflect.Type) (string, bool) {
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	name, ok := nameByType[t]
+	return name, ok
+}
+
+func lookupType(name string) (reflect.Type, bool) {
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	t, ok := typeByName[name]
+	return t, ok
+}
+
+// * Registered 返回通过 Register 或 RegisterName 注册过的类型名，按名字排序。
+// * 预先注册的基本类型不在其中。
+func Registered() []string {
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	names := make([]string, 0, len(userTypes))
+	for name := range userTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// * IsRegistered 判断 v 的具体类型能否放进接口类型的字段中编码。
+// * 直接用 gob.Register 注册的类型也算注册过。
+func IsRegistered(v interface{}) bool {
+	return isRegistered(reflect.TypeOf(v))
+}
+
+func isRegistered(t reflect.Type) bool {
+	if _, ok := lookupName(t); ok {
+		return true
+	}
+	return gobRegistered(t)
+}
+
+// * gob 没有查询注册表的接口，只能把 t 的值放进接口字段里试着编码一次。
+// * 依赖 gob 对没有注册的类型返回的错误信息，见 explainEncodeError。
+func gobRegistered(t reflect.Type) bool {
+	var v reflect.Value
+	if t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem()) //* gob 不能编码接口里的 nil 指针
+	} else {
+		v = reflect.Zero(t)
+	}
+	err := gob.NewEncoder(io.Discard).Encode(&struct{ V interface{} }{v.Interface()})
+	return !isUnregisteredErr(err)
+}
+
+// * MustBeRegistered 检查所有值的具体类型都已经注册，否则 panic 并列出没有注册的类型。
+// * 服务可以在启动时调用，例如 labgob.MustBeRegistered(Op{})。
+func MustBeRegistered(vs ...interface{}) {
+	var missing []string
+	for _, v := range vs {
+		if !IsRegistered(v) {
+			missing = append(missing, typeName(reflect.TypeOf(v)))
+		}
+	}
+	if len(missing) > 0 {
+		panic("labgob: types not registered: " + strings.Join(missing, ", ") + "; call labgob.Register for each at startup")
+	}
+}
+
+// *编码时接口字段里的具体类型没有注册
+type UnregisteredError struct {
+	Type string //* 没有注册的具体类型
+	Path string //* 它在被编码的值中的位置，例如 "Entries[3].Command"
+	Err  error  //* 底层编码器返回的错误
+}
+
+func (e *UnregisteredError) Error() string {
+	at := ""
+	if e.Path != "" {
+		at = " at " + e.Path
+	}
+	return fmt.Sprintf("labgob: type %v%v is not registered; call labgob.Register with a value of this type at startup", e.Type, at)
+}
+
+func (e *UnregisteredError) Unwrap() error {
+	return e.Err
+}
+
+var errUnregistered = errors.New("labgob: type not registered for interface")
+
+// * 在 v 中找第一个具体类型没有注册的接口值，找不到时返回 nil。
+func findUnregistered(v reflect.Value) *UnregisteredError {
+	return walkUnregistered(v, "", map[visit]bool{})
+}
+
+func walkUnregistered(v reflect.Value, path string, visited map[visit]bool) *UnregisteredError {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if !isRegistered(v.Elem().Type()) {
+			return &UnregisteredError{Type: typeName(v.Elem().Type()), Path: path}
+		}
+		return walkUnregistered(v.Elem(), path, visited)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		vis := visit{v.Pointer(), v.Type()}
+		if visited[vis] {
+			return nil
+		}
+		visited[vis] = true
+		return walkUnregistered(v.Elem(), path, visited)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			p := t.Field(i).Name
+			if path != "" {
+				p = path + "." + p
+			}
+			if e := walkUnregistered(v.Field(i), p, visited); e != nil {
+				return e
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if e := walkUnregistered(v.Index(i), fmt.Sprintf("%v[%v]", path, i), visited); e != nil {
+				return e
+			}
+		}
+	case reflect.Map:
+		it := v.MapRange()
+		for it.Next() {
+			if e := walkUnregistered(it.Value(), fmt.Sprintf("%v[%v]", path, it.Key()), visited); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// * 错误是不是因为接口里的具体类型没有注册。gob 没有导出对应的错误值，
+// * 只能匹配它的错误信息 "type not registered for interface: ..."
+// * （encoding/gob 的 encodeInterface）；Binary 编码的 errUnregistered 也用同样的措辞。
+// * 如果 gob 改了措辞，这里会认不出来，编码错误原样返回。
+func isUnregisteredErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "type not registered")
+}
+
+// * 编码 v 失败时，如果是因为接口里有没有注册的类型，换成 *UnregisteredError。
+func explainEncodeError(v interface{}, err error) error {
+	if !isUnregisteredErr(err) {
+		return err
+	}
+	if ue := findUnregistered(reflect.ValueOf(v)); ue != nil {
+		ue.Err = err
+		return ue
+	}
+	return err
+}
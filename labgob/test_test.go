@@ -3,6 +3,12 @@ package labgob
 import "testing"
 
 import "bytes"
+import "time"
+import "errors"
+import "fmt"
+import "strings"
+import "sync"
+import "encoding/gob"
 
 type T1 struct {
 	T1int0    int
@@ -168,4 +174,596 @@ func TestDefault(t *testing.T) {
 	if errorCount != e0+1 {
 		t.Fatalf("failed to warn about decoding into non-default value")
 	}
-}
\ No newline at end of file
+}
+
+type T5 struct {
+	Entries []T6
+}
+
+type T6 struct {
+	Term    int
+	command interface{}
+}
+
+//
+// strict mode returns a typed error instead of printing,
+// and the problem shows up in Diagnostics.
+//
+func TestStrict(t *testing.T) {
+	SetStrict(true)
+	defer SetStrict(false)
+	ResetDiagnostics()
+
+	w := new(bytes.Buffer)
+	err := NewEncoder(w).Encode(T5{})
+	ce, ok := err.(*CheckError)
+	if !ok || len(ce.Diagnostics) != 1 {
+		t.Fatalf("expected a CheckError with one diagnostic, got %v", err)
+	}
+	d := ce.Diagnostics[0]
+	if d.Kind != DiagLowerCase || d.Type != "labgob.T5" || d.Path != "Entries[].command" {
+		t.Fatalf("wrong diagnostic %+v", d)
+	}
+	if w.Len() != 0 {
+		t.Fatalf("strict Encode wrote data despite the error")
+	}
+
+	// the error is returned every time, not just the first time.
+	if _, ok := NewEncoder(w).Encode(&T5{}).(*CheckError); !ok {
+		t.Fatalf("second Encode did not return a CheckError")
+	}
+
+	type DD struct {
+		X int
+	}
+	w = new(bytes.Buffer)
+	NewEncoder(w).Encode(DD{})
+	reply := DD{99}
+	err = NewDecoder(w).Decode(&reply)
+	ce, ok = err.(*CheckError)
+	if !ok || len(ce.Diagnostics) != 1 || ce.Diagnostics[0].Kind != DiagNonDefault || ce.Diagnostics[0].Path != "X" {
+		t.Fatalf("expected a non-default CheckError, got %v", err)
+	}
+
+	ds := Diagnostics()
+	if len(ds) != 2 || ds[0].Type != "labgob.T6" || ds[0].Path != "command" || ds[1].Count != 1 {
+		t.Fatalf("wrong accumulated diagnostics %+v", ds)
+	}
+	ResetDiagnostics()
+	if len(Diagnostics()) != 0 {
+		t.Fatalf("ResetDiagnostics did not clear diagnostics")
+	}
+}
+
+type T7 struct {
+	Inner []T8
+}
+
+type T8 struct {
+	Name  string
+	value int
+}
+
+//
+// goroutines that check the same type at the same time all see the
+// lower-case field, not an empty result from a check in progress.
+//
+func TestStrictConcurrent(t *testing.T) {
+	SetStrict(true)
+	defer SetStrict(false)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- NewEncoder(new(bytes.Buffer)).Encode(T7{})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if _, ok := err.(*CheckError); !ok {
+			t.Fatalf("expected CheckError, got %v", err)
+		}
+	}
+}
+
+type C1 struct {
+	A int
+	B string
+	C []byte
+	D map[string]*C2
+	E interface{}
+	F [2]float64
+	G time.Time
+	H *C2
+	I []C2
+	J bool
+	K uint16
+}
+
+type C2 struct {
+	X int32
+	Y []string
+}
+
+//
+// every codec round-trips the same value.
+//
+func TestCodecs(t *testing.T) {
+	Register(C2{})
+
+	v := C1{
+		A: -7, B: "hello", C: []byte{1, 2, 3},
+		D: map[string]*C2{"a": {1, []string{"x"}}, "b": {-2, nil}},
+		E: C2{3, []string{"y", "z"}},
+		F: [2]float64{1.5, -2.25},
+		G: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		H: &C2{4, nil},
+		I: []C2{{5, nil}},
+		J: true, K: 65535,
+	}
+	for _, c := range []Codec{Gob, Binary, JSON} {
+		if CodecByName(c.Name()) != c {
+			t.Fatalf("CodecByName(%q) did not find the codec", c.Name())
+		}
+		data, err := c.Marshal(&v)
+		if err != nil {
+			t.Fatalf("%v: Marshal: %v", c.Name(), err)
+		}
+		var v1 C1
+		if err := c.Unmarshal(data, &v1); err != nil {
+			t.Fatalf("%v: Unmarshal: %v", c.Name(), err)
+		}
+		if c == JSON {
+			// JSON loses the concrete type of interface values.
+			v1.E = v.E
+		}
+		if v1.A != v.A || v1.B != v.B || !bytes.Equal(v1.C, v.C) || v1.D["a"].X != 1 || v1.D["a"].Y[0] != "x" ||
+			v1.D["b"].X != -2 || v1.E.(C2).Y[1] != "z" || v1.F != v.F || !v1.G.Equal(v.G) ||
+			v1.H.X != 4 || v1.I[0].X != 5 || !v1.J || v1.K != v.K {
+			t.Fatalf("%v: wrong round trip %+v", c.Name(), v1)
+		}
+	}
+
+	// the binary codec is more compact than gob for a single value.
+	g, _ := Gob.Marshal(v)
+	b, _ := Binary.Marshal(v)
+	if len(b) >= len(g) {
+		t.Fatalf("binary (%v bytes) not smaller than gob (%v bytes)", len(b), len(g))
+	}
+
+	// unregistered interface values are an error, as with gob.
+	type unregistered struct{ X int }
+	if _, err := Binary.Marshal(C1{E: unregistered{1}}); err == nil {
+		t.Fatalf("binary codec accepted an unregistered interface value")
+	}
+	// truncated input is an error, not a panic.
+	if err := Binary.Unmarshal(b[:len(b)/2], &C1{}); err == nil {
+		t.Fatalf("binary codec accepted truncated input")
+	}
+}
+
+type C3 struct {
+	Empty  []struct{}
+	Arrays [][0]int
+	Nil    []int
+	None   []int
+	Bytes  []byte
+	NoMap  map[string]int
+	NilPtr []*C2
+}
+
+//
+// the binary codec keeps nil and empty slices and maps apart, and
+// accepts slices whose elements encode to zero bytes.
+//
+func TestBinaryEdgeCases(t *testing.T) {
+	v := C3{
+		Empty:  make([]struct{}, 1000),
+		Arrays: make([][0]int, 3),
+		None:   []int{},
+		NilPtr: []*C2{nil},
+	}
+	data, err := Binary.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var v1 C3
+	if err := Binary.Unmarshal(data, &v1); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(v1.Empty) != 1000 || len(v1.Arrays) != 3 || v1.Nil != nil || v1.None == nil || len(v1.None) != 0 ||
+		v1.Bytes != nil || v1.NoMap != nil || len(v1.NilPtr) != 1 || v1.NilPtr[0] != nil {
+		t.Fatalf("wrong round trip %+v", v1)
+	}
+
+	v.Bytes = []byte{}
+	v.NoMap = map[string]int{}
+	data, _ = Binary.Marshal(v)
+	v1 = C3{}
+	if err := Binary.Unmarshal(data, &v1); err != nil || v1.Bytes == nil || v1.NoMap == nil {
+		t.Fatalf("empty []byte or map decoded as nil: %+v %v", v1, err)
+	}
+
+	// a corrupt length cannot make the decoder loop or allocate forever.
+	if err := Binary.Unmarshal([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, &[]struct{}{}); err == nil {
+		t.Fatalf("accepted a huge count of zero-size elements")
+	}
+	if err := Binary.Unmarshal([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, &[]int{}); err == nil {
+		t.Fatalf("accepted a count larger than the input")
+	}
+}
+
+// a type whose binary marshaling methods have pointer receivers.
+type PtrMarshaler struct {
+	A int
+	B int
+}
+
+func (pm *PtrMarshaler) MarshalBinary() ([]byte, error) {
+	return []byte(fmt.Sprintf("%v,%v", pm.A, pm.B)), nil
+}
+
+func (pm *PtrMarshaler) UnmarshalBinary(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "%d,%d", &pm.A, &pm.B)
+	return err
+}
+
+type C4 struct {
+	V    PtrMarshaler
+	P    *PtrMarshaler
+	NilP *PtrMarshaler
+	L    []PtrMarshaler
+	T    *time.Time
+}
+
+//
+// the encoder and decoder agree on which types use MarshalBinary,
+// whatever the receiver of the methods.
+//
+func TestBinaryMarshalerRoundTrip(t *testing.T) {
+	now := time.Now().Round(0)
+	v := C4{V: PtrMarshaler{1, 2}, P: &PtrMarshaler{3, 4}, L: []PtrMarshaler{{5, 6}}, T: &now}
+	data, err := Binary.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var v1 C4
+	if err := Binary.Unmarshal(data, &v1); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if v1.V != v.V || *v1.P != *v.P || v1.NilP != nil || len(v1.L) != 1 || v1.L[0] != v.L[0] || !v1.T.Equal(now) {
+		t.Fatalf("wrong round trip %+v", v1)
+	}
+	// the struct is encoded by MarshalBinary, not field by field.
+	if !bytes.Contains(data, []byte("1,2")) {
+		t.Fatalf("PtrMarshaler was not encoded with MarshalBinary")
+	}
+}
+
+type StateV1 struct {
+	Term int
+	Vote int
+}
+
+type StateV2 struct {
+	Vote   int
+	Term   int
+	Leader bool
+}
+
+//
+// versioned envelopes are checked and upgraded through registered migrations.
+//
+func TestVersioned(t *testing.T) {
+	const name = "labgob.TestState"
+
+	data, err := MarshalVersioned(Gob, name, StateV1{3, 1})
+	if err != nil || !IsVersioned(data) {
+		t.Fatalf("MarshalVersioned: %v", err)
+	}
+	if n, v, _, err := Open(data); err != nil || n != name || v != 1 {
+		t.Fatalf("Open gave %v %v %v", n, v, err)
+	}
+
+	// a damaged envelope is detected.
+	bad := append([]byte{}, data...)
+	bad[len(bad)-1] ^= 1
+	if _, err := Upgrade(bad); err != ErrEnvelopeCorrupt {
+		t.Fatalf("expected ErrEnvelopeCorrupt, got %v", err)
+	}
+
+	// bump the version without a migration.
+	RegisterVersion(name, 2)
+	var s2 StateV2
+	if err := UnmarshalVersioned(Gob, name, data, &s2); !errors.Is(err, ErrNoMigration) {
+		t.Fatalf("expected ErrNoMigration, got %v", err)
+	}
+
+	RegisterMigration(name, 1, func(payload []byte) ([]byte, error) {
+		var old StateV1
+		if err := Gob.Unmarshal(payload, &old); err != nil {
+			return nil, err
+		}
+		return Gob.Marshal(StateV2{Vote: old.Vote, Term: old.Term, Leader: old.Vote == 0})
+	})
+	if err := UnmarshalVersioned(Gob, name, data, &s2); err != nil || s2.Term != 3 || s2.Vote != 1 {
+		t.Fatalf("wrong migrated state %+v %v", s2, err)
+	}
+	up, _ := Upgrade(data)
+	if _, v, _, _ := Open(up); v != 2 {
+		t.Fatalf("upgraded envelope has version %v", v)
+	}
+
+	// data from a newer release is refused rather than misread.
+	RegisterVersion(name, 1)
+	if _, err := Upgrade(up); err == nil {
+		t.Fatalf("Upgrade accepted a newer version")
+	}
+	RegisterVersion(name, 2)
+
+	// the wrong type name is refused, and plain data passes through.
+	var s1 StateV1
+	if err := UnmarshalVersioned(Gob, "labgob.Other", data, &s1); err == nil {
+		t.Fatalf("UnmarshalVersioned accepted the wrong type name")
+	}
+	plain := []byte("plain")
+	if out, err := Upgrade(plain); err != nil || string(out) != "plain" {
+		t.Fatalf("plain data not passed through: %q %v", out, err)
+	}
+
+	// looking up an unknown type name does not add a schema for it.
+	n := len(schemas)
+	if CurrentVersion("labgob.Unknown") != 1 {
+		t.Fatalf("unknown type should be at version 1")
+	}
+	if _, err := Upgrade(Seal("labgob.Unknown2", nil)); err != nil || len(schemas) != n {
+		t.Fatalf("lookups added schemas: %v -> %v, %v", n, len(schemas), err)
+	}
+}
+
+type BenchEntry struct {
+	Command interface{}
+	Term    int
+}
+
+type BenchAppendEntries struct {
+	Term         int
+	LeaderId     int
+	PrevLogIndex int
+	PrevLogTerm  int
+	Entries      []BenchEntry
+	LeaderCommit int
+}
+
+type countWriter struct {
+	n int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+//
+// allocs/op and bytes per AppendEntries for a fresh encoder per
+// message, the pooled Marshal, one encoder per stream, and the
+// binary codec. pooling the buffer saves only a few allocations;
+// most of the cost is the new gob encoder, which only a stream
+// (one encoder per ordered connection) avoids.
+//
+func BenchmarkAppendEntries(b *testing.B) {
+	args := BenchAppendEntries{Term: 7, LeaderId: 1, PrevLogIndex: 100, PrevLogTerm: 6, LeaderCommit: 99}
+	for i := 0; i < 10; i++ {
+		args.Entries = append(args.Entries, BenchEntry{1000 + i, 7})
+	}
+
+	b.Run("NewEncoder", func(b *testing.B) {
+		b.ReportAllocs()
+		n := 0
+		for i := 0; i < b.N; i++ {
+			w := new(bytes.Buffer)
+			NewEncoder(w).Encode(args)
+			n += w.Len()
+		}
+		b.ReportMetric(float64(n)/float64(b.N), "bytes/msg")
+	})
+	b.Run("Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		n := 0
+		for i := 0; i < b.N; i++ {
+			data, _ := Marshal(args)
+			n += len(data)
+		}
+		b.ReportMetric(float64(n)/float64(b.N), "bytes/msg")
+	})
+	b.Run("Stream", func(b *testing.B) {
+		b.ReportAllocs()
+		w := &countWriter{}
+		enc := NewEncoder(w)
+		for i := 0; i < b.N; i++ {
+			enc.Encode(args)
+		}
+		b.ReportMetric(float64(w.n)/float64(b.N), "bytes/msg")
+	})
+	b.Run("Binary", func(b *testing.B) {
+		b.ReportAllocs()
+		n := 0
+		for i := 0; i < b.N; i++ {
+			data, _ := Binary.Marshal(args)
+			n += len(data)
+		}
+		b.ReportMetric(float64(n)/float64(b.N), "bytes/msg")
+	})
+}
+
+//
+// Marshal's result is not overwritten when its buffer is reused.
+//
+func TestMarshalPool(t *testing.T) {
+	a, _ := Marshal(T3{1})
+	b, _ := Marshal(T3{2})
+	var x, y T3
+	if Unmarshal(a, &x) != nil || Unmarshal(b, &y) != nil || x.T3int999 != 1 || y.T3int999 != 2 {
+		t.Fatalf("wrong values %v %v", x, y)
+	}
+}
+
+type D1 struct {
+	A D2
+	M map[string]int
+	N *D1
+}
+
+type D2 struct {
+	B D3
+}
+
+type D3 struct {
+	C D4
+}
+
+type D4 struct {
+	Entries []BenchEntry
+	Arr     [2]int
+	Deep    int
+}
+
+//
+// non-default decode targets are found at any depth, inside slices,
+// arrays and maps, without looping on cycles.
+//
+func TestDeepDefault(t *testing.T) {
+	SetStrict(true)
+	defer SetStrict(false)
+	ResetDiagnostics()
+
+	v := D1{}
+	v.A.B.C.Deep = 1
+	v.A.B.C.Entries = []BenchEntry{{nil, 0}, {nil, 3}}
+	v.A.B.C.Arr[1] = 2
+	v.M = map[string]int{"x": 1}
+	v.N = &v
+
+	w := new(bytes.Buffer)
+	NewEncoder(w).Encode(D1{})
+	err := NewDecoder(w).Decode(&v)
+	ce, ok := err.(*CheckError)
+	if !ok {
+		t.Fatalf("expected CheckError, got %v", err)
+	}
+	got := map[string]bool{}
+	for _, d := range ce.Diagnostics {
+		got[d.Path] = true
+	}
+	for _, p := range []string{"A.B.C.Deep", "A.B.C.Entries[].Term", "A.B.C.Arr[]", "M"} {
+		if !got[p] {
+			t.Fatalf("missing diagnostic for %v in %v", p, ce)
+		}
+	}
+	if len(ce.Diagnostics) != 4 {
+		t.Fatalf("expected 4 diagnostics, got %v", ce)
+	}
+
+	byType := DiagnosticsByType()
+	if len(byType) != 1 || len(byType["*labgob.D1"]) != 4 {
+		t.Fatalf("wrong per-type report %v", byType)
+	}
+}
+
+//
+// checking a zero-valued reply allocates nothing.
+//
+func TestCheckDefaultAllocs(t *testing.T) {
+	v := &T1{}
+	if n := testing.AllocsPerRun(100, func() { checkDefault(v) }); n != 0 {
+		t.Fatalf("checkDefault allocated %v times", n)
+	}
+}
+
+//
+// without strict mode or SetDeepCheck only the first element of a
+// slice is checked, so decoding a long slice stays cheap.
+//
+func TestDeepCheck(t *testing.T) {
+	ResetDiagnostics()
+	defer ResetDiagnostics()
+
+	entries := make([]BenchEntry, 10000)
+	entries[9999].Term = 1
+	if ds := checkDefault(&entries); len(ds) != 0 {
+		t.Fatalf("last element checked without SetDeepCheck: %v", ds)
+	}
+	entries[0].Term = 1
+	if ds := checkDefault(&entries); len(ds) != 1 || ds[0].Path != "[].Term" {
+		t.Fatalf("first element not checked: %v", ds)
+	}
+
+	entries[0].Term = 0
+	SetDeepCheck(true)
+	defer SetDeepCheck(false)
+	if ds := checkDefault(&entries); len(ds) != 1 || ds[0].Path != "[].Term" {
+		t.Fatalf("last element not checked with SetDeepCheck: %v", ds)
+	}
+}
+
+type RegOp struct {
+	Key string
+}
+
+type unregisteredOp struct {
+	Key string
+}
+
+type gobOnlyOp struct {
+	Key string
+}
+
+//
+// an unregistered command type is named, with its position, at Encode time.
+//
+func TestRegistration(t *testing.T) {
+	Register(RegOp{})
+	found := false
+	for _, name := range Registered() {
+		if name == "github.com/gyy0727/mit-6.824/labgob.RegOp" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("RegOp missing from Registered(): %v", Registered())
+	}
+	if !IsRegistered(RegOp{}) || !IsRegistered(5) || IsRegistered(unregisteredOp{}) {
+		t.Fatalf("wrong IsRegistered results")
+	}
+	if !IsRegistered(complex64(0)) || !IsRegistered([]int8{}) || !IsRegistered([]complex128{}) {
+		t.Fatalf("gob's basic types should be registered")
+	}
+	gob.Register(gobOnlyOp{})
+	if !IsRegistered(gobOnlyOp{}) {
+		t.Fatalf("type registered with gob.Register should count as registered")
+	}
+	if _, err := Gob.Marshal([]BenchEntry{{gobOnlyOp{"a"}, 1}, {unregisteredOp{"b"}, 1}}); !strings.Contains(fmt.Sprint(err), "[1].Command") {
+		t.Fatalf("expected the unregistered type at [1].Command, got %v", err)
+	}
+
+	entries := []BenchEntry{{RegOp{"a"}, 1}, {7, 1}, {unregisteredOp{"b"}, 1}}
+	for _, c := range []Codec{Gob, Binary} {
+		_, err := c.Marshal(entries)
+		var ue *UnregisteredError
+		if !errors.As(err, &ue) || ue.Path != "[2].Command" || !strings.HasSuffix(ue.Type, "labgob.unregisteredOp") {
+			t.Fatalf("%v: expected UnregisteredError at [2].Command, got %v", c.Name(), err)
+		}
+	}
+
+	MustBeRegistered(RegOp{}, "x")
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "unregisteredOp") {
+			t.Fatalf("MustBeRegistered did not panic naming the type: %v", r)
+		}
+	}()
+	MustBeRegistered(RegOp{}, unregisteredOp{})
+}
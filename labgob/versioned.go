@@ -0,0 +1,187 @@
+package labgob
+
+//
+// 带版本的封装，用于持久化的状态和快照。
+// 结构体的字段增删、调换顺序之后，旧版本写下的数据用新的类型解码会悄悄出错。
+// 封装记录了类型名和格式版本，读取时按注册的迁移函数逐版升级：
+//
+//	magic "LGV1" | crc32c(之后的所有内容) | version | len(type) | type | payload
+//
+// crc 是 4 字节大端整数，version 和 len 是 varint，payload 是编码后的值。
+//
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const envelopeMagic = "LGV1"
+
+var (
+	ErrEnvelopeCorrupt = errors.New("labgob: versioned envelope is corrupt")
+	ErrNoMigration     = errors.New("labgob: no migration registered")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// *一个类型的格式版本和迁移函数
+type schema struct {
+	version    int
+	migrations map[int]func(payload []byte) ([]byte, error) //* 从版本 v 升级到 v+1
+}
+
+var schemas = map[string]*schema{}
+
+// *没有注册过版本的类型使用的默认格式
+var defaultSchema = schema{version: 1}
+
+// * 查找 typeName 的格式，没有注册过时返回默认格式，不会插入新的表项。
+// * 调用者需持有 typeMu。
+func schemaFor(typeName string) *schema {
+	if s, ok := schemas[typeName]; ok {
+		return s
+	}
+	return &defaultSchema
+}
+
+// * 取得 typeName 的格式，没有时创建，只在注册时使用。
+// * 调用者需持有 typeMu。
+func addSchema(typeName string) *schema {
+	s, ok := schemas[typeName]
+	if !ok {
+		s = &schema{version: 1, migrations: map[int]func([]byte) ([]byte, error){}}
+		schemas[typeName] = s
+	}
+	return s
+}
+
+// * RegisterVersion 设置类型 typeName 当前的格式版本，默认是 1。
+func RegisterVersion(typeName string, version int) {
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	addSchema(typeName).version = version
+}
+
+// * RegisterMigration 注册把 typeName 的 payload 从版本 from 升级到 from+1 的函数。
+func RegisterMigration(typeName string, from int, migrate func(payload []byte) ([]byte, error)) {
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	addSchema(typeName).migrations[from] = migrate
+}
+
+// * 类型 typeName 当前的格式版本。
+func CurrentVersion(typeName string) int {
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	return schemaFor(typeName).version
+}
+
+// * IsVersioned 判断 data 是否是带版本的封装。
+func IsVersioned(data []byte) bool {
+	return len(data) >= len(envelopeMagic)+4 && string(data[:len(envelopeMagic)]) == envelopeMagic
+}
+
+// * Seal 用类型 typeName 当前的格式版本封装 payload。
+func Seal(typeName string, payload []byte) []byte {
+	return seal(typeName, CurrentVersion(typeName), payload)
+}
+
+func seal(typeName string, version int, payload []byte) []byte {
+	buf := make([]byte, 0, len(envelopeMagic)+4+2*binary.MaxVarintLen64+len(typeName)+len(payload))
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = binary.AppendUvarint(buf, uint64(version))
+	buf = binary.AppendUvarint(buf, uint64(len(typeName)))
+	buf = append(buf, typeName...)
+	buf = append(buf, payload...)
+	h := len(envelopeMagic)
+	binary.BigEndian.PutUint32(buf[h:h+4], crc32.Checksum(buf[h+4:], crcTable))
+	return buf
+}
+
+// * Open 打开封装，校验失败时返回 ErrEnvelopeCorrupt。
+func Open(data []byte) (typeName string, version int, payload []byte, err error) {
+	if !IsVersioned(data) {
+		return "", 0, nil, ErrEnvelopeCorrupt
+	}
+	h := len(envelopeMagic)
+	rest := data[h+4:]
+	if binary.BigEndian.Uint32(data[h:h+4]) != crc32.Checksum(rest, crcTable) {
+		return "", 0, nil, ErrEnvelopeCorrupt
+	}
+	v, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return "", 0, nil, ErrEnvelopeCorrupt
+	}
+	rest = rest[n:]
+	l, n := binary.Uvarint(rest)
+	if n <= 0 || l > uint64(len(rest)-n) {
+		return "", 0, nil, ErrEnvelopeCorrupt
+	}
+	rest = rest[n:]
+	return string(rest[:l]), int(v), rest[l:], nil
+}
+
+// * Upgrade 把封装中的 payload 升级到类型当前的格式版本，返回新的封装。
+// * 不是封装的数据原样返回，这样以前没有封装过的状态仍然可以读取。
+func Upgrade(data []byte) ([]byte, error) {
+	if !IsVersioned(data) {
+		return data, nil
+	}
+	typeName, version, payload, err := Open(data)
+	if err != nil {
+		return nil, err
+	}
+	typeMu.Lock()
+	s := schemaFor(typeName)
+	current := s.version
+	migrations := s.migrations
+	typeMu.Unlock()
+
+	if version == current {
+		return data, nil
+	}
+	if version > current {
+		return nil, fmt.Errorf("labgob: %v version %v is newer than current version %v", typeName, version, current)
+	}
+	for ; version < current; version++ {
+		typeMu.Lock()
+		migrate := migrations[version]
+		typeMu.Unlock()
+		if migrate == nil {
+			return nil, fmt.Errorf("%w for %v from version %v", ErrNoMigration, typeName, version)
+		}
+		if payload, err = migrate(payload); err != nil {
+			return nil, fmt.Errorf("labgob: migrate %v from version %v: %w", typeName, version, err)
+		}
+	}
+	return seal(typeName, current, payload), nil
+}
+
+// * MarshalVersioned 用 c 编码 v，并以 typeName 当前的格式版本封装。
+func MarshalVersioned(c Codec, typeName string, v interface{}) ([]byte, error) {
+	payload, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(typeName, payload), nil
+}
+
+// * UnmarshalVersioned 打开封装，必要时升级，再用 c 解码到 v。
+// * 封装中的类型名必须是 typeName。
+func UnmarshalVersioned(c Codec, typeName string, data []byte, v interface{}) error {
+	data, err := Upgrade(data)
+	if err != nil {
+		return err
+	}
+	name, _, payload, err := Open(data)
+	if err != nil {
+		return err
+	}
+	if name != typeName {
+		return fmt.Errorf("labgob: envelope holds %v, expected %v", name, typeName)
+	}
+	return c.Unmarshal(payload, v)
+}
@@ -0,0 +1,367 @@
+package labgobvet
+
+//
+// 静态检查通过 labgob 编码的类型，相当于 labgob 运行时检查的编译期版本，
+// 而且不需要等到某个类型第一次被编码时才发现问题。
+//
+// 被检查的调用：
+//
+//	(*labgob.LabEncoder).Encode(v)      v 的类型
+//	(*labgob.LabDecoder).Decode(v)      v 的类型，以及 v 是否指向非零值
+//	labgob.Codec 的 Marshal、Unmarshal  同 Encode、Decode
+//	labgob.Register(v), RegisterName   v 的类型
+//	(*labrpc.ClientEnd).Call(m, a, r)   a 和 r 的类型，以及 r 是否指向非零值
+//
+// 报告的问题：
+//
+//   - 类型中有未导出的字段，gob 会忽略它们；
+//   - 把具体类型的值存进被编码类型的接口字段，而这个类型没有用 labgob.Register 注册过，
+//     只看本包和依赖包中的注册，在下游包中注册的类型会被误报；
+//   - 解码到用非空复合字面量初始化的局部变量，gob 不会把字段覆盖为零值。
+//
+// Persister 保存的是 []byte，其中的结构体都是先经过 labgob.Encode 的，所以也被覆盖到。
+//
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"sort"
+	"strings"
+)
+
+const (
+	labgobPath = "github.com/gyy0727/mit-6.824/labgob"
+	labrpcPath = "github.com/gyy0727/mit-6.824/labrpc"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name:      "labgobvet",
+	Doc:       "check types passed to labgob and labrpc for unexported fields, unregistered interface values and decoding into non-zero values",
+	Run:       run,
+	Requires:  []*analysis.Analyzer{inspect.Analyzer},
+	FactTypes: []analysis.Fact{new(registered)},
+}
+
+// *一个包用 labgob.Register 注册过的类型，作为包的 fact 传给依赖它的包
+type registered struct {
+	Types []string
+}
+
+func (*registered) AFact() {}
+
+func (r *registered) String() string {
+	return "registered(" + strings.Join(r.Types, ", ") + ")"
+}
+
+// *一个被检查的调用中各个参数的用途
+type argUse int
+
+const (
+	useEncode   argUse = iota //* 被编码
+	useDecode                 //* 被解码到其中
+	useRegister               //* 被注册
+)
+
+// *被检查的函数，按 types.Func.FullName 索引，值是各个参数的用途，-1 表示不检查
+var checkedFuncs = map[string][]argUse{
+	"(*" + labgobPath + ".LabEncoder).Encode": {useEncode},
+	"(*" + labgobPath + ".LabDecoder).Decode": {useDecode},
+	"(" + labgobPath + ".Codec).Marshal":      {useEncode},
+	"(" + labgobPath + ".Codec).Unmarshal":    {-1, useDecode},
+	labgobPath + ".Register":                  {useRegister},
+	labgobPath + ".RegisterName":              {-1, useRegister},
+	"(*" + labrpcPath + ".ClientEnd).Call":    {-1, useEncode, useDecode},
+}
+
+type checker struct {
+	pass       *analysis.Pass
+	registered map[string]bool       //* 本包和依赖包注册过的类型
+	encoded    map[*types.Named]bool //* 本包中被编码或解码过的命名类型
+	reported   map[string]bool
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	ins := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	c := &checker{
+		pass:       pass,
+		registered: map[string]bool{},
+		encoded:    map[*types.Named]bool{},
+		reported:   map[string]bool{},
+	}
+	for _, f := range pass.AllPackageFacts() {
+		for _, t := range f.Fact.(*registered).Types {
+			c.registered[t] = true
+		}
+	}
+
+	//* 第一遍：检查调用，收集注册过的类型和被编码的类型
+	var own []string
+	ins.WithStack([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push {
+			return true
+		}
+		call := n.(*ast.CallExpr)
+		uses, ok := checkedFuncs[calleeName(pass.TypesInfo, call)]
+		if !ok {
+			return true
+		}
+		for i, use := range uses {
+			if use < 0 || i >= len(call.Args) {
+				continue
+			}
+			arg := call.Args[i]
+			t := pass.TypesInfo.TypeOf(arg)
+			if t == nil {
+				continue
+			}
+			c.checkType(arg, t)
+			switch use {
+			case useRegister:
+				name := typeName(t)
+				c.registered[name] = true
+				own = append(own, name)
+			case useDecode:
+				c.checkNonZero(arg, stack)
+			}
+		}
+		return true
+	})
+	if len(own) > 0 {
+		sort.Strings(own)
+		pass.ExportPackageFact(&registered{own})
+	}
+
+	//* 第二遍：检查存进被编码类型的接口字段的值
+	ins.Preorder([]ast.Node{(*ast.CompositeLit)(nil), (*ast.AssignStmt)(nil)}, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.CompositeLit:
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.Ident); ok {
+						c.checkStore(pass.TypesInfo.ObjectOf(key), kv.Value)
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			if len(n.Lhs) != len(n.Rhs) {
+				return
+			}
+			for i, lhs := range n.Lhs {
+				if sel, ok := lhs.(*ast.SelectorExpr); ok {
+					c.checkStore(pass.TypesInfo.ObjectOf(sel.Sel), n.Rhs[i])
+				}
+			}
+		}
+	})
+	return nil, nil
+}
+
+// * 被调用函数的全名，不是函数调用时返回空串。
+func calleeName(info *types.Info, call *ast.CallExpr) string {
+	var id *ast.Ident
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	default:
+		return ""
+	}
+	fn, ok := info.Uses[id].(*types.Func)
+	if !ok {
+		return ""
+	}
+	return fn.FullName()
+}
+
+// * 去掉所有指针。
+func deref(t types.Type) types.Type {
+	for {
+		p, ok := t.(*types.Pointer)
+		if !ok {
+			return t
+		}
+		t = p.Elem()
+	}
+}
+
+// * 去掉指针后的类型名，和 gob 注册时使用的具体类型对应。
+func typeName(t types.Type) string {
+	return types.TypeString(deref(t), nil)
+}
+
+// * gob 自己注册过的类型：基本类型和基本类型的切片。
+func preregistered(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return true
+	case *types.Slice:
+		_, ok := u.Elem().Underlying().(*types.Basic)
+		return ok
+	}
+	return false
+}
+
+// * 实现了 GobEncoder 或 BinaryMarshaler 的类型自己负责编码，不检查字段。
+func customEncoding(t types.Type) bool {
+	ms := types.NewMethodSet(types.NewPointer(t))
+	for i := 0; i < ms.Len(); i++ {
+		switch ms.At(i).Obj().Name() {
+		case "GobEncode", "MarshalBinary":
+			return true
+		}
+	}
+	return false
+}
+
+// * 用于报告的去掉指针后的类型名，只用包名限定。
+func shortName(t types.Type) string {
+	return types.TypeString(deref(t), func(p *types.Package) string { return p.Name() })
+}
+
+// * 检查在 at 处被编码或解码的类型 t。
+func (c *checker) checkType(at ast.Node, t types.Type) {
+	c.walk(at, t, "", shortName(t), map[types.Type]bool{})
+}
+
+func (c *checker) walk(at ast.Node, t types.Type, path string, top string, seen map[types.Type]bool) {
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	if named, ok := t.(*types.Named); ok {
+		if customEncoding(named) {
+			return
+		}
+		c.encoded[named] = true
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Pointer:
+		c.walk(at, u.Elem(), path, top, seen)
+	case *types.Slice:
+		c.walk(at, u.Elem(), path+"[]", top, seen)
+	case *types.Array:
+		c.walk(at, u.Elem(), path+"[]", top, seen)
+	case *types.Map:
+		c.walk(at, u.Key(), path+"[key]", top, seen)
+		c.walk(at, u.Elem(), path+"[]", top, seen)
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			p := f.Name()
+			if path != "" {
+				p = path + "." + p
+			}
+			if !f.Exported() {
+				c.report(at, "labgob: unexported field %v of %v is not sent by gob", p, top)
+			}
+			c.walk(at, f.Type(), p, top, seen)
+		}
+	}
+}
+
+// * 检查把 value 存进字段 obj：如果 obj 是被编码类型的接口字段，
+// * value 的具体类型需要注册过。
+func (c *checker) checkStore(obj types.Object, value ast.Expr) {
+	field, ok := obj.(*types.Var)
+	if !ok || !field.IsField() || !types.IsInterface(field.Type()) {
+		return
+	}
+	if !c.fieldOfEncoded(field) {
+		return
+	}
+	vt := c.pass.TypesInfo.TypeOf(value)
+	if vt == nil || types.IsInterface(vt) || preregistered(vt) {
+		return
+	}
+	if !c.registered[typeName(vt)] {
+		c.report(value, "labgob: %v stored in interface field %v is not registered with labgob.Register", shortName(vt), field.Name())
+	}
+}
+
+// * 字段是否属于本包中被编码或解码过的类型。
+func (c *checker) fieldOfEncoded(field *types.Var) bool {
+	for named := range c.encoded {
+		st, ok := named.Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			if st.Field(i) == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// * 检查解码的目标 &x：x 是用非空复合字面量初始化的局部变量时报告。
+func (c *checker) checkNonZero(arg ast.Expr, stack []ast.Node) {
+	u, ok := arg.(*ast.UnaryExpr)
+	if !ok {
+		return
+	}
+	id, ok := u.X.(*ast.Ident)
+	if !ok {
+		return
+	}
+	obj := c.pass.TypesInfo.ObjectOf(id)
+	if obj == nil {
+		return
+	}
+	//* 在所在的函数里找 x 的声明
+	var body *ast.BlockStmt
+	for i := len(stack) - 1; i >= 0 && body == nil; i-- {
+		switch fn := stack[i].(type) {
+		case *ast.FuncDecl:
+			body = fn.Body
+		case *ast.FuncLit:
+			body = fn.Body
+		}
+	}
+	if body == nil {
+		return
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		var lhs []ast.Expr
+		var rhs []ast.Expr
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			lhs, rhs = n.Lhs, n.Rhs
+		case *ast.ValueSpec:
+			for _, name := range n.Names {
+				lhs = append(lhs, name)
+			}
+			rhs = n.Values
+		default:
+			return true
+		}
+		if len(lhs) != len(rhs) {
+			return true
+		}
+		for i, l := range lhs {
+			lid, ok := l.(*ast.Ident)
+			if !ok || c.pass.TypesInfo.ObjectOf(lid) != obj || lid.Pos() >= arg.Pos() {
+				continue
+			}
+			if lit, ok := rhs[i].(*ast.CompositeLit); ok && len(lit.Elts) > 0 {
+				c.report(arg, "labgob: decoding into %v, which holds non-zero values; gob does not overwrite fields with zero values", id.Name)
+			}
+		}
+		return true
+	})
+}
+
+func (c *checker) report(at ast.Node, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	key := fmt.Sprint(at.Pos(), msg)
+	if c.reported[key] {
+		return
+	}
+	c.reported[key] = true
+	c.pass.Reportf(at.Pos(), "%s", msg)
+}
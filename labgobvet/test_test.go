@@ -0,0 +1,8 @@
+package labgobvet
+
+import "testing"
+import "golang.org/x/tools/go/analysis/analysistest"
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "a")
+}
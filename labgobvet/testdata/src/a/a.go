@@ -0,0 +1,69 @@
+package a // want package:"registered\\(a.Op\\)"
+
+import (
+	"b"
+	"github.com/gyy0727/mit-6.824/labgob"
+	"github.com/gyy0727/mit-6.824/labrpc"
+	"time"
+)
+
+type LogEntry struct {
+	Term    int
+	Command interface{}
+}
+
+type State struct {
+	Log   []LogEntry
+	voted int
+	When  time.Time
+}
+
+type Op struct {
+	Key string
+}
+
+type Unregistered struct {
+	Key string
+}
+
+type Args struct {
+	Inner *Inner
+}
+
+type Inner struct {
+	id int
+}
+
+type Reply struct {
+	Value string
+}
+
+func encode(enc *labgob.LabEncoder, dec *labgob.LabDecoder) {
+	labgob.Register(Op{})
+
+	st := State{}
+	st.Log = append(st.Log, LogEntry{Term: 1, Command: Op{"k"}})
+	st.Log = append(st.Log, LogEntry{Term: 1, Command: 5})
+	st.Log = append(st.Log, LogEntry{Term: 1, Command: b.Registered{}})
+	st.Log = append(st.Log, LogEntry{Term: 1, Command: Unregistered{"k"}}) // want `labgob: a.Unregistered stored in interface field Command is not registered with labgob.Register`
+	e := LogEntry{}
+	e.Command = &Unregistered{} // want `labgob: a.Unregistered stored in interface field Command is not registered with labgob.Register`
+	enc.Encode(st)              // want `labgob: unexported field voted of a.State is not sent by gob`
+
+	var zero State
+	dec.Decode(&zero) // want `labgob: unexported field voted of a.State is not sent by gob`
+	full := Reply{"x"}
+	dec.Decode(&full) // want `labgob: decoding into full, which holds non-zero values; gob does not overwrite fields with zero values`
+}
+
+func call(end *labrpc.ClientEnd) {
+	args := Args{}
+	reply := Reply{}
+	end.Call("KV.Get", &args, &reply) // want `labgob: unexported field Inner.id of a.Args is not sent by gob`
+}
+
+func codec(c labgob.Codec) {
+	c.Marshal(Args{}) // want `labgob: unexported field Inner.id of a.Args is not sent by gob`
+	full := Reply{"x"}
+	c.Unmarshal(nil, &full) // want `labgob: decoding into full, which holds non-zero values; gob does not overwrite fields with zero values`
+}
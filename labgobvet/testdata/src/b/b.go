@@ -0,0 +1,11 @@
+package b
+
+import "github.com/gyy0727/mit-6.824/labgob"
+
+type Registered struct {
+	X int
+}
+
+func init() {
+	labgob.Register(Registered{})
+}
@@ -0,0 +1,19 @@
+package labgob
+
+type LabEncoder struct{}
+
+func (enc *LabEncoder) Encode(e interface{}) error { return nil }
+
+type LabDecoder struct{}
+
+func (dec *LabDecoder) Decode(e interface{}) error { return nil }
+
+func Register(value interface{}) {}
+
+func RegisterName(name string, value interface{}) {}
+
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
@@ -0,0 +1,5 @@
+package labrpc
+
+type ClientEnd struct{}
+
+func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bool { return true }
@@ -0,0 +1,65 @@
+package labrpc
+
+//
+// 选择参数和回复的编码方式。
+// 一次调用使用的编码方式按以下顺序决定：
+// ClientEnd.SetCodec，目标 Service 的 SetCodec，Network.SetCodec，默认的 labgob.Gob。
+// 服务器总是用请求的编码方式解码参数、编码回复，所以两端不会不一致。
+//
+
+import (
+	"github.com/gyy0727/mit-6.824/labgob"
+	"strings"
+)
+
+// * 设置网络的默认编码方式，nil 表示 labgob.Gob。
+func (rn *Network) SetCodec(c labgob.Codec) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if c == nil {
+		c = labgob.Gob
+	}
+	rn.codec = c
+}
+
+// * 设置调用这个服务时使用的编码方式，nil 表示由网络决定。
+func (svc *Service) SetCodec(c labgob.Codec) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.codec = c
+}
+
+func (svc *Service) getCodec() labgob.Codec {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return svc.codec
+}
+
+// * 设置端点发出的调用使用的编码方式，nil 表示由网络或服务决定。
+// * TCP 端点没有网络，默认使用 labgob.Gob。
+func (e *ClientEnd) SetCodec(c labgob.Codec) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.codec = c
+}
+
+// * 端点 endname 调用 svcMeth 时使用的编码方式：目标服务的设置，否则是网络的默认值。
+func (rn *Network) codecFor(endname interface{}, svcMeth string) labgob.Codec {
+	rn.mu.Lock()
+	c := rn.codec
+	server := rn.servers[rn.connections[endname]]
+	rn.mu.Unlock()
+
+	dot := strings.LastIndex(svcMeth, ".")
+	if server != nil && dot >= 0 {
+		server.mu.Lock()
+		svc := server.services[svcMeth[:dot]]
+		server.mu.Unlock()
+		if svc != nil {
+			if sc := svc.getCodec(); sc != nil {
+				return sc
+			}
+		}
+	}
+	return c
+}
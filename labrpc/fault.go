@@ -0,0 +1,220 @@
+package labrpc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+)
+
+// *延迟的分布类型
+type DelayDist int
+
+const (
+	DelayFixed       DelayDist = iota //* 固定为 Min
+	DelayUniform                      //* 在 [Min, Max) 中均匀分布
+	DelayExponential                  //* Min 加上均值为 Mean 的指数分布，Max 非零时截断到 Max
+	DelayNested                       //* Min 加上 [0, u] 中的均匀分布，u 在 [0, Max-Min) 中均匀分布
+)
+
+// *一种延迟分布，零值表示没有延迟
+type Delay struct {
+	Dist DelayDist
+	Min  time.Duration
+	Max  time.Duration
+	Mean time.Duration
+}
+
+// * 固定延迟 d。
+func FixedDelay(d time.Duration) Delay {
+	return Delay{Dist: DelayFixed, Min: d}
+}
+
+// * 在 [min, max) 中均匀分布的延迟。
+func UniformDelay(min time.Duration, max time.Duration) Delay {
+	return Delay{Dist: DelayUniform, Min: min, Max: max}
+}
+
+// * min 加上均值为 mean 的指数分布，max 非零时不超过 max。
+func ExponentialDelay(min time.Duration, mean time.Duration, max time.Duration) Delay {
+	return Delay{Dist: DelayExponential, Min: min, Mean: mean, Max: max}
+}
+
+// * 先均匀地取一个上限，再在 [0, 上限] 中均匀取值，短延迟多、长延迟少。
+// * LongReordering 默认使用 NestedDelay(200ms, 2200ms)，
+// * 即原来的 200 + rand.Intn(1+rand.Intn(2000)) 毫秒。
+func NestedDelay(min time.Duration, max time.Duration) Delay {
+	return Delay{Dist: DelayNested, Min: min, Max: max}
+}
+
+// * 按分布抽取一个延迟。
+func (d Delay) sample(rng *rand.Rand) time.Duration {
+	switch d.Dist {
+	case DelayUniform:
+		if d.Max <= d.Min {
+			return d.Min
+		}
+		return d.Min + time.Duration(rng.Int64N(int64(d.Max-d.Min)))
+	case DelayExponential:
+		x := d.Min + time.Duration(rng.ExpFloat64()*float64(d.Mean))
+		if d.Max > 0 && x > d.Max {
+			x = d.Max
+		}
+		return x
+	case DelayNested:
+		if d.Max <= d.Min {
+			return d.Min
+		}
+		u := rng.Int64N(int64(d.Max - d.Min))
+		return d.Min + time.Duration(rng.Int64N(1+u))
+	default:
+		return d.Min
+	}
+}
+
+// *网络的故障模型。
+// *可以整体设置在 Network 上，也可以针对某条链路单独覆盖。
+type NetworkConfig struct {
+	RequestDrop   float64 //* 请求被丢弃的概率
+	ReplyDrop     float64 //* 回复被丢弃的概率
+	RequestDelay  Delay   //* 请求送达服务器前的延迟
+	ReorderProb   float64 //* 回复被推迟（造成乱序）的概率
+	ReorderDelay  Delay   //* 被推迟的回复的延迟
+	DeadDelay     Delay   //* 服务器不可达时，返回失败前等待的时间
+	DuplicateProb float64 //* 请求被重复投递给服务器的概率，每个请求最多多投递 maxDuplicates 次
+	Bandwidth     int64   //* 链路带宽（字节/秒），0 表示不限
+}
+
+// * 一个请求最多被额外投递的次数
+const maxDuplicates = 3
+
+// * 由 Reliable、LongDelays、LongReordering、Duplicate 这几个开关得到的故障模型，
+// * 与这些开关原本的行为一致。
+func defaultConfig(reliable bool, longDelays bool, longReordering bool, duplicate float64) NetworkConfig {
+	cfg := NetworkConfig{}
+	cfg.DuplicateProb = duplicate
+	if reliable == false {
+		cfg.RequestDrop = 0.1
+		cfg.ReplyDrop = 0.1
+		cfg.RequestDelay = UniformDelay(0, 27*time.Millisecond)
+	}
+	if longReordering {
+		cfg.ReorderProb = 600.0 / 900.0
+		cfg.ReorderDelay = NestedDelay(200*time.Millisecond, 2200*time.Millisecond)
+	}
+	if longDelays {
+		//* 让 Raft 测试检查领导者是否没有同步发送 RPC。
+		cfg.DeadDelay = UniformDelay(0, 7000*time.Millisecond)
+	} else {
+		//* 许多 kv 测试要求客户端快速尝试每个服务器。
+		cfg.DeadDelay = UniformDelay(0, 100*time.Millisecond)
+	}
+	return cfg
+}
+
+// * 以概率 p 返回 true。
+func chance(rng *rand.Rand, p float64) bool {
+	return p > 0 && rng.Float64() < p
+}
+
+// * 没有指定种子时使用的种子：环境变量 LABRPC_SEED，否则取当前时间。
+func defaultSeed() int64 {
+	if s := os.Getenv("LABRPC_SEED"); s != "" {
+		if seed, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return seed
+		}
+	}
+	return time.Now().UnixNano()
+}
+
+// * 为端点 endname 的第 seq 次调用生成随机数发生器。
+// * 所有丢包、延迟、乱序的决定都来自它，所以只要种子相同、
+// * 每个端点上的调用顺序相同，重放时的故障决定也完全相同，
+// * 不受 goroutine 调度顺序的影响。
+func (rn *Network) rngFor(endname interface{}, seq uint64) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprint(h, endname)
+	return rand.New(rand.NewPCG(uint64(rn.seed), h.Sum64()^(seq*0x9e3779b97f4a7c15)))
+}
+
+// * 网络使用的随机种子。
+// * 用 MakeNetworkSeed(seed) 或环境变量 LABRPC_SEED 重放同样的故障。
+func (rn *Network) Seed() int64 {
+	return rn.seed
+}
+
+// *测试框架中用于报告失败的部分，*testing.T 满足这个接口
+type FailureReporter interface {
+	Cleanup(func())
+	Failed() bool
+	Logf(format string, args ...interface{})
+}
+
+// * 测试失败时打印网络的随机种子，方便重放。
+func (rn *Network) LogSeedOnFailure(t FailureReporter) {
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("labrpc: network seed %v; rerun with LABRPC_SEED=%v to replay fault decisions", rn.seed, rn.seed)
+		}
+	})
+}
+
+// * 设置整个网络的故障模型，覆盖 Reliable、LongDelays、LongReordering、Duplicate 的设置。
+// * cfg 为 nil 时恢复由这几个开关决定的默认行为。
+func (rn *Network) SetConfig(cfg *NetworkConfig) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if cfg == nil {
+		rn.config = nil
+		return
+	}
+	c := *cfg
+	rn.config = &c
+}
+
+// * 为从节点 from 到服务器 to 的链路单独设置故障模型，
+// * 请求和回复都使用它。cfg 为 nil 时取消覆盖。
+func (rn *Network) SetLinkConfig(from interface{}, to interface{}, cfg *NetworkConfig) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if cfg == nil {
+		delete(rn.linkConfigs, link{from, to})
+		return
+	}
+	c := *cfg
+	rn.linkConfigs[link{from, to}] = &c
+}
+
+// * 从节点 from 到服务器 to 生效的故障模型，调用者需持有 rn.mu。
+func (rn *Network) configFor(from interface{}, to interface{}) NetworkConfig {
+	if c, ok := rn.linkConfigs[link{from, to}]; ok {
+		return *c
+	}
+	if rn.config != nil {
+		return *rn.config
+	}
+	return defaultConfig(rn.reliable, rn.longDelays, rn.longReordering, rn.duplicate)
+}
+
+// * 在链路 l 上传输 n 字节，按带宽 bw 排队，返回需要等待的时间。
+// * 同一条链路上的消息依次占用带宽。
+func (rn *Network) transmit(l link, n int, bw int64) time.Duration {
+	if bw <= 0 {
+		return 0
+	}
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	now := rn.clock.Now()
+	start := rn.linkBusy[l]
+	if start.Before(now) {
+		start = now
+	}
+	end := start.Add(time.Duration(int64(n) * int64(time.Second) / bw))
+	rn.linkBusy[l] = end
+	return end.Sub(now)
+}
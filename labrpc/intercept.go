@@ -0,0 +1,65 @@
+package labrpc
+
+//
+// 拦截器（中间件）。
+// 客户端拦截器包在 ClientEnd.Call 外面，服务端拦截器包在 RPC 处理函数外面，
+// 可以观察或修改 svcMeth、参数和回复，也可以不调用 next 直接返回，
+// 返回 false 表示这次调用失败（对调用方来说就像回复丢失）。
+//
+// 例如让服务器 2 丢掉所有 AppendEntries：
+//
+//	servers[2].Use(func(svcMeth string, args, reply interface{}, next Handler) bool {
+//		if svcMeth == "Raft.AppendEntries" {
+//			return false
+//		}
+//		return next(svcMeth, args, reply)
+//	})
+//
+
+// *发出一次调用，签名与 ClientEnd.Call 相同
+type Invoker func(svcMeth string, args interface{}, reply interface{}) bool
+
+// *客户端拦截器，args 是传给 Call 的参数，reply 是接收回复的指针
+type ClientInterceptor func(svcMeth string, args interface{}, reply interface{}, next Invoker) bool
+
+// *执行一次调用；args 是指向解码后参数的指针，reply 是指向回复的指针
+type Handler func(svcMeth string, args interface{}, reply interface{}) bool
+
+// *服务端拦截器
+type ServerInterceptor func(svcMeth string, args interface{}, reply interface{}, next Handler) bool
+
+// * 给端点添加客户端拦截器，先添加的在外层。
+func (e *ClientEnd) Use(ics ...ClientInterceptor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.interceptors = append(append([]ClientInterceptor{}, e.interceptors...), ics...)
+}
+
+// * 给服务器添加服务端拦截器，先添加的在外层，对服务器上所有服务生效。
+func (rs *Server) Use(ics ...ServerInterceptor) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.interceptors = append(append([]ServerInterceptor{}, rs.interceptors...), ics...)
+}
+
+// * 把拦截器串在 invoker 外面。
+func chainClient(ics []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(ics) - 1; i >= 0; i-- {
+		ic, next := ics[i], invoker
+		invoker = func(svcMeth string, args interface{}, reply interface{}) bool {
+			return ic(svcMeth, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// * 把拦截器串在 handler 外面。
+func chainServer(ics []ServerInterceptor, handler Handler) Handler {
+	for i := len(ics) - 1; i >= 0; i-- {
+		ic, next := ics[i], handler
+		handler = func(svcMeth string, args interface{}, reply interface{}) bool {
+			return ic(svcMeth, args, reply, next)
+		}
+	}
+	return handler
+}
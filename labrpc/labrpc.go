@@ -1,10 +1,10 @@
 package labrpc
 
 import (
-	"bytes"
 	"github.com/gyy0727/mit-6.824/labgob"
+	"github.com/gyy0727/mit-6.824/labtime"
 	"log"
-	"math/rand"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
@@ -19,6 +19,10 @@ type reqMsg struct {
 	argsType reflect.Type  //*参数类型
 	args     []byte        //*参数
 	replyCh  chan replyMsg //*回复的消息
+	seq      uint64        //*该端点上的第几次调用，用于生成随机数
+	codec    labgob.Codec  //*参数和回复的编码方式
+	argv     interface{}   //*已经解码的参数（指针），非 nil 时不再解码 args
+	replyv   *interface{}  //*非 nil 时返回值直接放在这里，不再编码
 }
 
 // *响应消息
@@ -29,9 +33,29 @@ type replyMsg struct {
 
 // *客户端终端
 type ClientEnd struct {
-	endname interface{}   //*终端的名字
-	ch      chan reqMsg   //*要发送的请求消息的通道
-	done    chan struct{} //*清理网络时关闭
+	endname      interface{}         //*终端的名字
+	tr           transport           //*把请求送到服务器的传输层
+	seq          uint64              //*已发出的调用数
+	mu           sync.Mutex          //*保护 interceptors 和 codec
+	interceptors []ClientInterceptor //*客户端拦截器
+	codec        labgob.Codec        //*端点指定的编码方式，nil 表示由传输层决定
+}
+
+// *把 ClientEnd 的请求送到服务器的传输层。
+// *模拟网络 Network 和真实的 TCP 连接（tcpTransport）都实现了它。
+type transport interface {
+	//* 发出请求，回复稍后从 req.replyCh 返回；返回 false 表示传输层已关闭。
+	send(req reqMsg) bool
+	//* 释放传输层占用的资源。
+	close()
+	//* 端点 endname 调用 svcMeth 时使用的编码方式。
+	codecFor(endname interface{}, svcMeth string) labgob.Codec
+}
+
+// *可以直接在连接的 gob 流上收发参数和回复的传输层，见 tcpTransport。
+// *编码方式是 gob 时 ClientEnd 不再单独编码参数，由传输层完成整个调用。
+type streamTransport interface {
+	streamCall(req reqMsg, args interface{}, reply interface{}) bool
 }
 
 // *具有可通过RPC调用的方法的对象。
@@ -41,13 +65,18 @@ type Service struct {
 	rcvr    reflect.Value             //*接收方法调用的对象,服务对应的函数实例
 	typ     reflect.Type              //*接收方法调用的对象的类型
 	methods map[string]reflect.Method //*注册的方法
+	limiter limiter                   //*服务的并发限制
+	mu      sync.Mutex                //*保护 codec
+	codec   labgob.Codec              //*服务希望使用的编码方式，nil 表示由网络决定
 }
 
 // *rpc服务器。
 type Server struct {
-	mu       sync.Mutex          //* 保护服务的互斥锁。
-	services map[string]*Service //* 注册的服务，按名称映射。
-	count    int                 //* 注册的服务数量。
+	mu           sync.Mutex          //* 保护服务的互斥锁。
+	services     map[string]*Service //* 注册的服务，按名称映射。
+	count        int                 //* 收到的请求数。
+	limiter      limiter             //* 整个服务器的并发限制。
+	interceptors []ServerInterceptor //* 服务端拦截器。
 }
 
 // *维持客户端和服务端的通信
@@ -56,6 +85,7 @@ type Network struct {
 	reliable       bool                        //* 是否是可靠网络
 	longDelays     bool                        //* 在禁用连接时，发送时是否会暂停很长时间
 	longReordering bool                        //* 是否有时会延迟很长时间来回复
+	duplicate      float64                     //* 请求被重复投递的概率
 	ends           map[interface{}]*ClientEnd  //* 存储各个端点（ClientEnd），按名称映射
 	enabled        map[interface{}]bool        //* 各端点是否启用，按端点名称映射
 	servers        map[interface{}]*Server     //* 存储各个服务器（Server），按名称映射
@@ -64,37 +94,74 @@ type Network struct {
 	done           chan struct{}               //* 在网络清理时关闭的通道
 	count          int32                       //* 总的 RPC 调用次数，用于统计
 	bytes          int64                       //* 发送的总字节数，用于统计
+	dups           int32                       //* 重复投递的请求数，用于统计
+	owners         map[interface{}]interface{} //* 端点所属的节点名，用于计算分区
+	partition      map[interface{}]int         //* 节点名到分区编号的映射，nil 表示没有分区
+	cut            map[link]bool               //* 被单向切断的链路
+	config         *NetworkConfig              //* 整个网络的故障模型，nil 表示由开关决定
+	linkConfigs    map[link]*NetworkConfig     //* 针对单条链路的故障模型
+	linkBusy       map[link]time.Time          //* 各链路带宽被占用到的时刻
+	seed           int64                       //* 故障注入使用的随机种子
+	tracer         Tracer                      //* RPC 跟踪器，nil 表示不跟踪
+	stats          *netStats                   //* 各端点、各服务器上按方法的统计
+	rules          []*installedRule            //* 有针对性的故障注入规则
+	clock          labtime.Clock               //* 延迟、超时使用的时钟
+	nextRule       int                         //* 下一条规则的编号
+	codec          labgob.Codec                //* 默认的编码方式
+}
+
+// *一条有向链路：从节点 from 发往节点 to 的消息
+type link struct {
+	from interface{}
+	to   interface{}
 }
 
 // *发送RPC，等待回复。
 // *返回值表示成功；false意味着
 // *未收到服务器的回复。
 func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bool {
+	e.mu.Lock()
+	ics := e.interceptors
+	e.mu.Unlock()
+	if len(ics) == 0 {
+		return e.call(svcMeth, args, reply)
+	}
+	return chainClient(ics, e.call)(svcMeth, args, reply)
+}
+
+// *真正发出RPC，位于所有客户端拦截器的最内层。
+func (e *ClientEnd) call(svcMeth string, args interface{}, reply interface{}) bool {
 	req := reqMsg{}
 	req.endname = e.endname
 	req.svcMeth = svcMeth
 	req.argsType = reflect.TypeOf(args)
 	req.replyCh = make(chan replyMsg)
-	qb := new(bytes.Buffer)
-	qe := labgob.NewEncoder(qb) //*新建一个编码器
-	qe.Encode(args)             //*编码参数
-	req.args = qb.Bytes()       //*将编码后的参数赋值给req.args
+	req.seq = atomic.AddUint64(&e.seq, 1)
+	e.mu.Lock()
+	req.codec = e.codec
+	e.mu.Unlock()
+	if req.codec == nil {
+		req.codec = e.tr.codecFor(e.endname, svcMeth)
+	}
+	if st, ok := e.tr.(streamTransport); ok && req.codec == labgob.Gob {
+		return st.streamCall(req, args, reply)
+	}
+	qb, err := req.codec.Marshal(args) //*编码参数
+	if err != nil {
+		log.Fatalf("ClientEnd.Call(): encode args: %v\n", err)
+	}
+	req.args = qb //*将编码后的参数赋值给req.args
 
 	//*发送rpc请求
-	select {
-	case e.ch <- req:
-		//*发送成功，等待回复。
-	case <-e.done:
-		//* 网络已关闭，无法发送请求。
+	if e.tr.send(req) == false {
+		//* 传输层已关闭，无法发送请求。
 		return false
 	}
 
 	//*等待回复
 	rep := <-req.replyCh
 	if rep.ok {
-		rb := bytes.NewBuffer(rep.reply)
-		rd := labgob.NewDecoder(rb)
-		if err := rd.Decode(reply); err != nil {
+		if err := req.codec.Unmarshal(rep.reply, reply); err != nil {
 			log.Fatalf("ClientEnd.Call(): decode reply: %v\n", err)
 		}
 		return true
@@ -103,6 +170,12 @@ func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bo
 	}
 }
 
+// *关闭端点。对模拟网络中的端点没有作用，
+// *对 TCP 端点会关闭连接池中的连接。
+func (e *ClientEnd) Close() {
+	e.tr.close()
+}
+
 // *创建服务
 func MakeService(rcvr interface{}) *Service {
 	svc := &Service{}
@@ -137,33 +210,58 @@ func MakeService(rcvr interface{}) *Service {
 }
 
 // *执行对应服务
-func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
+// *ics 是服务器上的拦截器，返回 false 时调用失败，不回复。
+func (svc *Service) dispatch(methname string, req reqMsg, ics []ServerInterceptor) replyMsg {
 	if method, ok := svc.methods[methname]; ok {
 		//* 准备读取参数的空间。
 		//* args 的类型将是 req.argsType 的指针类型。
-		args := reflect.New(req.argsType)
-
-		//* 解码参数。
-		ab := bytes.NewBuffer(req.args)
-		ad := labgob.NewDecoder(ab)
-		ad.Decode(args.Interface())
+		//* 从 TCP 来的请求不带参数类型，按方法签名解码。
+		argsType := req.argsType
+		if argsType == nil {
+			argsType = method.Type.In(1)
+		}
+		//* 用请求的编码方式解码参数，传输层已经解码过的直接使用。
+		var args reflect.Value
+		if req.argv != nil {
+			args = reflect.ValueOf(req.argv)
+		} else {
+			args = reflect.New(argsType)
+			req.codec.Unmarshal(req.args, args.Interface())
+		}
 
 		//* 为返回值分配空间。
 		replyType := method.Type.In(2)
 		replyType = replyType.Elem()
 		replyv := reflect.New(replyType)
 
-		//* 调用方法。
+		//* 经过拦截器调用方法。
 		function := method.Func
-		function.Call([]reflect.Value{svc.rcvr, args.Elem(), replyv})
+		handler := func(svcMeth string, a interface{}, r interface{}) bool {
+			function.Call([]reflect.Value{svc.rcvr, reflect.ValueOf(a).Elem(), reflect.ValueOf(r)})
+			return true
+		}
+		if len(ics) > 0 {
+			if chainServer(ics, handler)(req.svcMeth, args.Interface(), replyv.Interface()) == false {
+				return replyMsg{false, nil}
+			}
+		} else {
+			handler(req.svcMeth, args.Interface(), replyv.Interface())
+		}
+
+		//* 由传输层在流上编码返回值。
+		if req.replyv != nil {
+			*req.replyv = replyv.Interface()
+			return replyMsg{true, nil}
+		}
 
-		//* 编码返回值。
-		rb := new(bytes.Buffer)
-		re := labgob.NewEncoder(rb)
-		re.EncodeValue(replyv)
+		//* 用同样的编码方式编码返回值。
+		rb, err := req.codec.Marshal(replyv.Interface())
+		if err != nil {
+			log.Fatalf("labrpc.Service.dispatch(): encode reply of %v: %v\n", req.svcMeth, err)
+		}
 
 		//* 返回响应消息。
-		return replyMsg{true, rb.Bytes()}
+		return replyMsg{true, rb}
 	} else {
 		//* 如果方法没有找到，列出所有可用的方法并打印错误。
 		choices := []string{}
@@ -195,11 +293,23 @@ func (rs *Server) dispatch(req reqMsg) replyMsg {
 	methodName := req.svcMeth[dot+1:]
 
 	service, ok := rs.services[serviceName]
+	ics := rs.interceptors
 
 	rs.mu.Unlock()
 
 	if ok {
-		return service.dispatch(methodName, req)
+		//* 按服务和服务器的并发限制排队，被拒绝的请求返回失败。
+		//* 先取服务的名额，在服务的队列里等待时不占服务器的名额，
+		//* 一个饱和的服务不会挡住同一服务器上的其他服务。
+		if service.limiter.acquire() == false {
+			return replyMsg{false, nil}
+		}
+		defer service.limiter.release()
+		if rs.limiter.acquire() == false {
+			return replyMsg{false, nil}
+		}
+		defer rs.limiter.release()
+		return service.dispatch(methodName, req, ics)
 	} else {
 		choices := []string{}
 		for k, _ := range rs.services {
@@ -211,6 +321,32 @@ func (rs *Server) dispatch(req reqMsg) replyMsg {
 	}
 }
 
+// * svcMeth 是否是这个服务器上存在的 "服务.方法"。
+// * 来自 TCP 的名字不可信，dispatch 之前先检查，避免格式错误的名字让服务器退出。
+func (rs *Server) hasMethod(svcMeth string) bool {
+	_, ok := rs.argsTypeOf(svcMeth)
+	return ok
+}
+
+// * svcMeth 的参数类型，方法不存在时返回 false。
+func (rs *Server) argsTypeOf(svcMeth string) (reflect.Type, bool) {
+	dot := strings.LastIndex(svcMeth, ".")
+	if dot < 0 {
+		return nil, false
+	}
+	rs.mu.Lock()
+	service, ok := rs.services[svcMeth[:dot]]
+	rs.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	method, ok := service.methods[svcMeth[dot+1:]]
+	if !ok {
+		return nil, false
+	}
+	return method.Type.In(1), true
+}
+
 // *添加服务
 func (rs *Server) AddService(svc *Service) {
 	rs.mu.Lock()
@@ -242,6 +378,77 @@ func (rn *Network) Enable(endname interface{}, enabled bool) {
 	rn.enabled[endname] = enabled
 }
 
+// * 设置端点所属的节点，分区按节点计算。
+// * 未设置时，端点自身的名字就是它所属的节点。
+func (rn *Network) SetEndOwner(endname interface{}, owner interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.owners[endname] = owner
+}
+
+// * 把网络划分成若干互不连通的组。
+// * 每个组列出节点名（服务器名，或通过 SetEndOwner 设置的端点所属节点），
+// * 不同组之间的请求和回复都会被丢弃；没有出现在任何组里的节点不受影响。
+// * 再次调用会替换之前的分区，但保留单向分区。
+func (rn *Network) Partition(groups ...[]interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.partition = map[interface{}]int{}
+	for i, group := range groups {
+		for _, node := range group {
+			rn.partition[node] = i
+		}
+	}
+}
+
+// * 单向分区：从 from 中的节点发往 to 中的节点的消息全部丢弃，反方向不受影响。
+// * 例如 PartitionOneWay([]interface{}{2}, []interface{}{0})
+// * 让 0 发给 2 的请求照常到达并执行，但 2 的回复无法回到 0。
+func (rn *Network) PartitionOneWay(from []interface{}, to []interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for _, f := range from {
+		for _, t := range to {
+			rn.cut[link{f, t}] = true
+		}
+	}
+}
+
+// * 清除所有分区，包括单向分区。
+func (rn *Network) Heal() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.partition = nil
+	rn.cut = map[link]bool{}
+}
+
+// * 端点所属的节点，调用者需持有 rn.mu。
+func (rn *Network) nodeOf(endname interface{}) interface{} {
+	if owner, ok := rn.owners[endname]; ok {
+		return owner
+	}
+	return endname
+}
+
+// * 从节点 from 发往节点 to 的消息能否到达，调用者需持有 rn.mu。
+func (rn *Network) reachable(from interface{}, to interface{}) bool {
+	if rn.cut[link{from, to}] {
+		return false
+	}
+	if rn.partition != nil {
+		gf, okf := rn.partition[from]
+		gt, okt := rn.partition[to]
+		if okf && okt && gf != gt {
+			return false
+		}
+	}
+	return true
+}
+
 // * 获取一个服务器的传入 RPC 请求数。
 func (rn *Network) GetCount(servername interface{}) int {
 	rn.mu.Lock()
@@ -257,6 +464,12 @@ func (rn *Network) GetTotalCount() int {
 	return int(x)
 }
 
+// * 获取网络重复投递的请求数。
+func (rn *Network) GetDuplicateCount() int {
+	x := atomic.LoadInt32(&rn.dups)
+	return int(x)
+}
+
 // * 获取网络的总字节数。
 func (rn *Network) GetTotalBytes() int64 {
 	x := atomic.LoadInt64(&rn.bytes)
@@ -264,13 +477,33 @@ func (rn *Network) GetTotalBytes() int64 {
 }
 
 // * 创建一个新的网络实例。
+// * 随机种子取自环境变量 LABRPC_SEED，没有设置时取当前时间。
+// * 设置了环境变量 LABRPC_LOG_SEED 时打印选中的种子；
+// * 测试中一般用 LogSeedOnFailure，只在失败时打印。
 func MakeNetwork() *Network {
+	seed := defaultSeed()
+	if os.Getenv("LABRPC_LOG_SEED") != "" {
+		log.Printf("labrpc: network seed %v; rerun with LABRPC_SEED=%v to replay fault decisions\n", seed, seed)
+	}
+	return MakeNetworkSeed(seed)
+}
+
+// * 用给定的随机种子创建网络，相同的种子产生相同的故障决定。
+func MakeNetworkSeed(seed int64) *Network {
 	rn := &Network{}
+	rn.seed = seed
 	rn.reliable = true
 	rn.ends = map[interface{}]*ClientEnd{}
 	rn.enabled = map[interface{}]bool{}
 	rn.servers = map[interface{}]*Server{}
 	rn.connections = map[interface{}](interface{}){}
+	rn.owners = map[interface{}]interface{}{}
+	rn.cut = map[link]bool{}
+	rn.linkConfigs = map[link]*NetworkConfig{}
+	rn.linkBusy = map[link]time.Time{}
+	rn.stats = makeNetStats()
+	rn.clock = labtime.Real
+	rn.codec = labgob.Gob
 	rn.endCh = make(chan reqMsg)
 	rn.done = make(chan struct{})
 
@@ -291,6 +524,21 @@ func MakeNetwork() *Network {
 	return rn
 }
 
+// * 把请求交给网络的分发 goroutine。
+func (rn *Network) send(req reqMsg) bool {
+	select {
+	case rn.endCh <- req:
+		return true
+	case <-rn.done:
+		//* 网络已关闭
+		return false
+	}
+}
+
+// * 网络端点不持有资源，由 Cleanup 统一清理。
+func (rn *Network) close() {
+}
+
 // * 清理网络，关闭相关 goroutine。
 func (rn *Network) Cleanup() {
 	close(rn.done)
@@ -312,6 +560,33 @@ func (rn *Network) LongReordering(yes bool) {
 	rn.longReordering = yes
 }
 
+// * 设置请求被重复投递的概率，0 表示关闭。
+// * 重复的请求会在稍后再次交给服务器执行，其回复被丢弃，
+// * 用来检验处理函数是否幂等、去重表是否正确。
+func (rn *Network) Duplicate(prob float64) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.duplicate = prob
+}
+
+// * 设置网络使用的时钟，默认是真实时钟。
+// * 换成 labtime.VirtualClock 后，所有延迟和超时都按虚拟时间计算。
+// * 应该在网络开始处理请求之前设置。
+func (rn *Network) SetClock(clock labtime.Clock) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.clock = clock
+}
+
+func (rn *Network) getClock() labtime.Clock {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.clock
+}
+
 // * 设置是否启用长时间延迟。
 func (rn *Network) LongDelays(yes bool) {
 	rn.mu.Lock()
@@ -321,23 +596,26 @@ func (rn *Network) LongDelays(yes bool) {
 }
 
 // * 读取 ClientEnd 的信息。
+// * 如果分区使请求无法到达服务器，enabled 为 false。
+// * cfg 是这条链路上生效的故障模型。
 func (rn *Network) readEndnameInfo(endname interface{}) (enabled bool,
-	servername interface{}, server *Server, reliable bool, longreordering bool,
+	servername interface{}, server *Server, node interface{}, cfg NetworkConfig,
 ) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
 	enabled = rn.enabled[endname]
 	servername = rn.connections[endname]
+	node = rn.nodeOf(endname)
 	if servername != nil {
 		server = rn.servers[servername]
+		enabled = enabled && rn.reachable(node, servername)
 	}
-	reliable = rn.reliable
-	longreordering = rn.longReordering
+	cfg = rn.configFor(node, servername)
 	return
 }
 
-// * 检查服务器是否已死亡。
+// * 检查服务器是否已死亡（被删除、端点被禁用或者被分区隔开）。
 func (rn *Network) isServerDead(endname interface{}, servername interface{}, server *Server) bool {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
@@ -345,26 +623,63 @@ func (rn *Network) isServerDead(endname interface{}, servername interface{}, ser
 	if rn.enabled[endname] == false || rn.servers[servername] != server {
 		return true
 	}
-	return false
+	return !rn.reachable(rn.nodeOf(endname), servername)
+}
+
+// * 服务器的回复能否回到端点。
+func (rn *Network) replyReachable(endname interface{}, servername interface{}) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.reachable(servername, rn.nodeOf(endname))
 }
 
 // * 处理请求。
 func (rn *Network) processReq(req reqMsg) {
-	enabled, servername, server, reliable, longreordering := rn.readEndnameInfo(req.endname)
+	enabled, servername, server, node, cfg := rn.readEndnameInfo(req.endname)
+	rng := rn.rngFor(req.endname, req.seq)
+	clk := rn.getClock()
+	sent := clk.Now()
+	rn.statCall(req, servername)
 
 	if enabled && servername != nil && server != nil {
-		if reliable == false {
-			//* 短延迟
-			ms := (rand.Int() % 27)
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+		//* 短延迟
+		delay := cfg.RequestDelay.sample(rng)
+		if delay > 0 {
+			clk.Sleep(delay)
 		}
 
-		if reliable == false && (rand.Int()%1000) < 100 {
+		ruleDrop, ruleDelay := rn.applyRules(OnRequest, node, servername, req.svcMeth)
+		if ruleDelay > 0 {
+			clk.Sleep(ruleDelay)
+			delay += ruleDelay
+		}
+
+		if ruleDrop || chance(rng, cfg.RequestDrop) {
 			//* 丢弃请求，返回超时
+			rn.traceRequest(req, node, servername, TraceDropped, sent, delay)
+			rn.statFail(req, servername, true)
 			req.replyCh <- replyMsg{false, nil}
 			return
 		}
 
+		//* 按带宽把请求发到服务器。
+		if d := rn.transmit(link{node, servername}, len(req.args), cfg.Bandwidth); d > 0 {
+			clk.Sleep(d)
+			delay += d
+		}
+		rn.traceRequest(req, node, servername, TraceDelivered, sent, delay)
+
+		//* 重复投递，多出来的副本各自延迟后执行，结果被丢弃
+		for i := 0; i < maxDuplicates && chance(rng, cfg.DuplicateProb); i++ {
+			atomic.AddInt32(&rn.dups, 1)
+			d := cfg.RequestDelay.sample(rng) + cfg.ReorderDelay.sample(rng)
+			rn.traceRequest(req, node, servername, TraceDuplicated, clk.Now(), d)
+			clk.AfterFunc(d, func() {
+				rn.deliverDuplicate(req, node, servername, server)
+			})
+		}
+
 		//* 执行请求（调用 RPC 处理函数）。
 		//* 在一个单独的线程中执行，以便我们可以定期检查
 		//* 服务器是否已死亡，如果是，则 RPC 需要返回错误。
@@ -376,62 +691,102 @@ func (rn *Network) processReq(req reqMsg) {
 
 		//* 等待处理函数返回，
 		//* 但如果 DeleteServer() 被调用，停止等待并返回错误。
+		//* 同一时间只有一个检查定时器，收到回复后停掉它，
+		//* 否则在 VirtualClock 上每个 RPC 都会留下一个要被推进的定时器。
 		var reply replyMsg
 		replyOK := false
 		serverDead := false
+		poll := make(chan struct{}, 1)
+		arm := func() labtime.Timer {
+			return clk.AfterFunc(100*time.Millisecond, func() { poll <- struct{}{} })
+		}
+		timer := arm()
 		for replyOK == false && serverDead == false {
 			select {
 			case reply = <-ech:
 				replyOK = true
-			case <-time.After(100 * time.Millisecond):
+			case <-poll:
 				serverDead = rn.isServerDead(req.endname, servername, server)
 				if serverDead {
 					go func() {
 						<-ech //* 清空 channel 以让之前创建的 goroutine 终止
 					}()
+				} else {
+					timer = arm()
 				}
 			}
 		}
+		timer.Stop()
 
 		//* 如果服务器已经被删除，则不回复。
 		serverDead = rn.isServerDead(req.endname, servername, server)
 
 		if replyOK == false || serverDead == true {
 			//* 服务器在等待期间已死亡，返回错误。
+			rn.traceReply(req, node, servername, 0, TraceDead, 0)
+			rn.statFail(req, servername, false)
+			req.replyCh <- replyMsg{false, nil}
+		} else if rn.replyReachable(req.endname, servername) == false {
+			//* 单向分区，回复回不到端点，返回超时
+			rn.traceReply(req, node, servername, len(reply.reply), TracePartitioned, 0)
+			rn.statFail(req, servername, true)
 			req.replyCh <- replyMsg{false, nil}
-		} else if reliable == false && (rand.Int()%1000) < 100 {
+		} else if ruleDrop, ruleDelay = rn.applyRules(OnReply, node, servername, req.svcMeth); ruleDrop || chance(rng, cfg.ReplyDrop) {
 			//* 丢弃回复，返回超时
+			rn.traceReply(req, node, servername, len(reply.reply), TraceDropped, 0)
+			rn.statFail(req, servername, true)
 			req.replyCh <- replyMsg{false, nil}
-		} else if longreordering == true && rand.Intn(900) < 600 {
-			//* 延迟回复
-			ms := 200 + rand.Intn(1+rand.Intn(2000))
-			time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
+		} else {
+			ms := rn.transmit(link{servername, node}, len(reply.reply), cfg.Bandwidth) + ruleDelay
+			decision := TraceDelivered
+			if ruleDelay > 0 {
+				decision = TraceDelayed
+			}
+			if chance(rng, cfg.ReorderProb) {
+				//* 延迟回复
+				ms += cfg.ReorderDelay.sample(rng)
+				decision = TraceDelayed
+			}
+			rn.traceReply(req, node, servername, len(reply.reply), decision, ms)
+			if ms > 0 {
+				clk.AfterFunc(ms, func() {
+					atomic.AddInt64(&rn.bytes, int64(len(reply.reply)))
+					rn.statReply(req, servername, reply, clk.Now().Sub(sent))
+					req.replyCh <- reply
+				})
+			} else {
 				atomic.AddInt64(&rn.bytes, int64(len(reply.reply)))
+				rn.statReply(req, servername, reply, clk.Now().Sub(sent))
 				req.replyCh <- reply
-			})
-		} else {
-			atomic.AddInt64(&rn.bytes, int64(len(reply.reply)))
-			req.replyCh <- reply
+			}
 		}
 	} else {
 		//* 模拟无回复并最终超时。
-		ms := 0
-		if rn.longDelays {
-			//* 让 Raft 测试检查领导者是否没有同步发送
-			//* RPC。
-			ms = (rand.Int() % 7000)
-		} else {
-			//* 许多 kv 测试要求客户端快速尝试每个
-			//* 服务器。
-			ms = (rand.Int() % 100)
-		}
-		time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
+		ms := cfg.DeadDelay.sample(rng)
+		rn.traceRequest(req, node, servername, TraceUnreachable, sent, ms)
+		rn.statFail(req, servername, false)
+		clk.AfterFunc(ms, func() {
 			req.replyCh <- replyMsg{false, nil}
 		})
 	}
 
 }
 
+// * 投递请求的一个重复副本，回复被丢弃。
+// * 和正常投递一样计入总请求数、字节数、服务器的统计和追踪，
+// * 并经过 Server.dispatch 的并发限制。
+func (rn *Network) deliverDuplicate(req reqMsg, node interface{}, servername interface{}, server *Server) {
+	if rn.isServerDead(req.endname, servername, server) {
+		rn.traceReply(req, node, servername, 0, TraceDead, 0)
+		return
+	}
+	atomic.AddInt32(&rn.count, 1)
+	atomic.AddInt64(&rn.bytes, int64(len(req.args)))
+	rn.statDuplicate(req, servername)
+	reply := server.dispatch(req)
+	rn.traceReply(req, node, servername, len(reply.reply), TraceDuplicated, 0)
+}
+
 // * 创建一个客户端端点。
 // * 启动一个线程来监听和处理。
 func (rn *Network) MakeEnd(endname interface{}) *ClientEnd {
@@ -444,8 +799,7 @@ func (rn *Network) MakeEnd(endname interface{}) *ClientEnd {
 
 	e := &ClientEnd{}
 	e.endname = endname
-	e.ch = rn.endCh
-	e.done = rn.done
+	e.tr = rn
 	rn.ends[endname] = e
 	rn.enabled[endname] = false
 	rn.connections[endname] = nil
@@ -464,7 +818,12 @@ func (rn *Network) AddServer(servername interface{}, rs *Server) {
 // * 从网络中删除服务器。
 func (rn *Network) DeleteServer(servername interface{}) {
 	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
+	rs := rn.servers[servername]
 	rn.servers[servername] = nil
+	rn.mu.Unlock()
+
+	//* 还在排队的请求不会再被执行
+	if rs != nil {
+		rs.failQueued()
+	}
 }
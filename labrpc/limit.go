@@ -0,0 +1,148 @@
+package labrpc
+
+import "sync"
+
+// *并发限制。
+// *最多 MaxInFlight 个请求同时执行，超出的按到达顺序排队，
+// *队列最多 MaxQueue 个；队列满时新请求被拒绝，调用方的 Call 返回 false。
+// *MaxQueue 为 0 时即为纯拒绝模式：超出 MaxInFlight 的请求立即被拒绝。
+// *MaxInFlight 为 0 表示不限制。
+type Limits struct {
+	MaxInFlight int
+	MaxQueue    int
+}
+
+// *并发限制的统计
+type LimitStats struct {
+	InFlight  int   //* 正在执行的请求数
+	Queued    int   //* 正在排队的请求数
+	MaxQueued int   //* 队列出现过的最大长度
+	Rejected  int64 //* 被拒绝的请求数
+}
+
+// *Server 的统计
+type ServerStats struct {
+	Count      int                   //* 收到的请求数，同 GetCount
+	LimitStats                       //* Server 级别的并发限制
+	Services   map[string]LimitStats //* 各个 Service 的并发限制，按服务名
+}
+
+// *按 Limits 放行请求
+type limiter struct {
+	mu        sync.Mutex
+	limits    Limits
+	inFlight  int
+	queue     []chan bool //* 排队等待的请求，放行时发送 true，服务器被删除时发送 false
+	maxQueued int
+	rejected  int64
+}
+
+// * 设置限制。提高 MaxInFlight 时立即放行排队的请求。
+func (l *limiter) setLimits(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	l.admit()
+}
+
+// * 申请执行一个请求，必要时排队；返回 false 表示请求被拒绝。
+// * 返回 true 时，执行完毕后必须调用 release。
+func (l *limiter) acquire() bool {
+	l.mu.Lock()
+	if l.limits.MaxInFlight <= 0 || l.inFlight < l.limits.MaxInFlight {
+		l.inFlight += 1
+		l.mu.Unlock()
+		return true
+	}
+	if len(l.queue) >= l.limits.MaxQueue {
+		l.rejected += 1
+		l.mu.Unlock()
+		return false
+	}
+	ch := make(chan bool, 1)
+	l.queue = append(l.queue, ch)
+	if len(l.queue) > l.maxQueued {
+		l.maxQueued = len(l.queue)
+	}
+	l.mu.Unlock()
+
+	//* admit 放行时已经替我们占了名额
+	return <-ch
+}
+
+// * 请求执行完毕，空出的名额交给队首的请求。
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight -= 1
+	l.admit()
+}
+
+// * 在名额允许的范围内按顺序放行排队的请求，调用者需持有 l.mu。
+func (l *limiter) admit() {
+	for len(l.queue) > 0 && (l.limits.MaxInFlight <= 0 || l.inFlight < l.limits.MaxInFlight) {
+		l.inFlight += 1
+		l.queue[0] <- true
+		l.queue = l.queue[1:]
+	}
+}
+
+// * 让所有排队的请求失败，用于服务器被删除时。
+// * 之后到达的请求照常处理，同一个 Server 还可以再加入网络。
+func (l *limiter) fail() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ch := range l.queue {
+		ch <- false
+	}
+	l.queue = nil
+}
+
+func (l *limiter) stats() LimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LimitStats{l.inFlight, len(l.queue), l.maxQueued, l.rejected}
+}
+
+// * 设置整个 Server 的并发限制。
+func (rs *Server) SetLimits(limits Limits) {
+	rs.limiter.setLimits(limits)
+}
+
+// * 设置单个 Service 的并发限制，与 Server 的限制同时生效。
+func (svc *Service) SetLimits(limits Limits) {
+	svc.limiter.setLimits(limits)
+}
+
+// * 获取 Server 的统计信息。
+func (rs *Server) Stats() ServerStats {
+	rs.mu.Lock()
+	services := make([]*Service, 0, len(rs.services))
+	for _, svc := range rs.services {
+		services = append(services, svc)
+	}
+	st := ServerStats{Count: rs.count}
+	rs.mu.Unlock()
+
+	st.LimitStats = rs.limiter.stats()
+	st.Services = map[string]LimitStats{}
+	for _, svc := range services {
+		st.Services[svc.name] = svc.limiter.stats()
+	}
+	return st
+}
+
+// * 让 Server 和各个 Service 上排队的请求失败。
+func (rs *Server) failQueued() {
+	rs.mu.Lock()
+	services := make([]*Service, 0, len(rs.services))
+	for _, svc := range rs.services {
+		services = append(services, svc)
+	}
+	rs.mu.Unlock()
+
+	rs.limiter.fail()
+	for _, svc := range services {
+		svc.limiter.fail()
+	}
+}
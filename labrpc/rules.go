@@ -0,0 +1,113 @@
+package labrpc
+
+import "time"
+
+// *规则作用于请求还是回复
+type FaultPhase int
+
+const (
+	OnRequest FaultPhase = iota //* 请求从端点发往服务器
+	OnReply                     //* 回复从服务器发回端点
+)
+
+// *规则对匹配的消息做什么
+type FaultAction int
+
+const (
+	FaultDrop  FaultAction = iota //* 丢弃消息，调用返回 false
+	FaultDelay                    //* 把消息推迟 Delay
+)
+
+// *有针对性的故障注入规则，按方法和节点匹配消息。例如：
+// *
+// *	丢掉服务器 1 接下来的 3 个 RequestVote 回复：
+// *	FaultRule{SvcMeth: "Raft.RequestVote", To: 1, Phase: OnReply, Count: 3}
+// *	把发往服务器 4 的 InstallSnapshot 推迟 2 秒：
+// *	FaultRule{SvcMeth: "Raft.InstallSnapshot", To: 4, Action: FaultDelay, Delay: 2 * time.Second}
+// *	每 5 个 AppendEntries 请求丢掉 1 个：
+// *	FaultRule{SvcMeth: "Raft.AppendEntries", Every: 5}
+type FaultRule struct {
+	SvcMeth string        //* 匹配的方法，"" 表示任意方法
+	From    interface{}   //* 发起调用的节点（见 SetEndOwner），nil 表示任意
+	To      interface{}   //* 服务器名，nil 表示任意
+	Phase   FaultPhase    //* 作用于请求还是回复
+	Action  FaultAction   //* 丢弃还是推迟
+	Delay   time.Duration //* FaultDelay 推迟的时间
+	Count   int           //* 生效 Count 次后自动移除，0 表示一直生效
+	Every   int           //* 只对每第 Every 个匹配的消息生效，0 表示每个都生效
+}
+
+// *已安装的规则
+type installedRule struct {
+	id      int
+	rule    FaultRule
+	matched int //* 匹配过的消息数
+	applied int //* 生效过的次数
+}
+
+// * 安装一条规则，返回用于 RemoveRule 的编号。
+// * 规则按安装顺序依次检查，多条规则可以同时对一个消息生效。
+func (rn *Network) AddRule(rule FaultRule) int {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.nextRule += 1
+	rn.rules = append(rn.rules, &installedRule{id: rn.nextRule, rule: rule})
+	return rn.nextRule
+}
+
+// * 移除一条规则，规则已经不存在时什么也不做。
+func (rn *Network) RemoveRule(id int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for i, ir := range rn.rules {
+		if ir.id == id {
+			rn.rules = append(rn.rules[:i:i], rn.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// * 移除所有规则。
+func (rn *Network) ClearRules() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.rules = nil
+}
+
+func (r *FaultRule) matches(phase FaultPhase, from interface{}, to interface{}, svcMeth string) bool {
+	return r.Phase == phase &&
+		(r.SvcMeth == "" || r.SvcMeth == svcMeth) &&
+		(r.From == nil || r.From == from) &&
+		(r.To == nil || r.To == to)
+}
+
+// * 对一个消息应用规则，返回是否丢弃以及要推迟多久。
+func (rn *Network) applyRules(phase FaultPhase, from interface{}, to interface{}, svcMeth string) (drop bool, delay time.Duration) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	kept := rn.rules[:0:0]
+	for _, ir := range rn.rules {
+		r := &ir.rule
+		if r.matches(phase, from, to, svcMeth) {
+			ir.matched += 1
+			if r.Every <= 1 || ir.matched%r.Every == 0 {
+				if r.Action == FaultDrop {
+					drop = true
+				} else {
+					delay += r.Delay
+				}
+				ir.applied += 1
+				if r.Count > 0 && ir.applied >= r.Count {
+					continue
+				}
+			}
+		}
+		kept = append(kept, ir)
+	}
+	rn.rules = kept
+	return
+}
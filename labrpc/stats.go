@@ -0,0 +1,191 @@
+package labrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// *延迟直方图各个桶的上界：0.5ms, 1ms, 2ms, ... 约 16s，最后一个桶没有上界
+var histogramBounds = func() []time.Duration {
+	bounds := []time.Duration{}
+	for d := 500 * time.Microsecond; d <= 20*time.Second; d *= 2 {
+		bounds = append(bounds, d)
+	}
+	return bounds
+}()
+
+// *延迟直方图
+type Histogram struct {
+	Bounds []time.Duration //* 各个桶的上界（含）
+	Counts []int64         //* 各个桶的计数，比 Bounds 多一个溢出桶
+	Count  int64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func newHistogram() Histogram {
+	return Histogram{Bounds: histogramBounds, Counts: make([]int64, len(histogramBounds)+1)}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i] += 1
+	h.Count += 1
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// * 平均延迟。
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// * 分位数 q（0 到 1）的上界估计，即落入的桶的上界。
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	target := int64(q * float64(h.Count))
+	var seen int64
+	for i, c := range h.Counts {
+		seen += c
+		if seen > target || seen == h.Count {
+			if i < len(h.Bounds) {
+				return h.Bounds[i]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+// *某个 RPC 方法的统计
+type MethodStats struct {
+	Calls        int64     //* 发出的调用数，服务器的统计中包括重复投递的副本
+	Duplicates   int64     //* 重复投递的副本数，只记在服务器的统计中
+	Drops        int64     //* 被网络丢掉的请求或回复数（随机丢包、单向分区）
+	Timeouts     int64     //* 没有收到回复、Call 返回 false 的调用数
+	RequestBytes int64     //* 请求的字节数
+	ReplyBytes   int64     //* 送达的回复的字节数
+	Latency      Histogram //* 成功调用从发出到收到回复的延迟
+}
+
+func (ms *MethodStats) copy() MethodStats {
+	c := *ms
+	c.Latency.Counts = append([]int64{}, ms.Latency.Counts...)
+	return c
+}
+
+// *按端点和服务器分别记录的各方法统计
+type netStats struct {
+	mu      sync.Mutex
+	ends    map[interface{}]map[string]*MethodStats
+	servers map[interface{}]map[string]*MethodStats
+}
+
+func makeNetStats() *netStats {
+	return &netStats{
+		ends:    map[interface{}]map[string]*MethodStats{},
+		servers: map[interface{}]map[string]*MethodStats{},
+	}
+}
+
+func lookupStats(m map[interface{}]map[string]*MethodStats, who interface{}, svcMeth string) *MethodStats {
+	byMeth, ok := m[who]
+	if !ok {
+		byMeth = map[string]*MethodStats{}
+		m[who] = byMeth
+	}
+	ms, ok := byMeth[svcMeth]
+	if !ok {
+		ms = &MethodStats{Latency: newHistogram()}
+		byMeth[svcMeth] = ms
+	}
+	return ms
+}
+
+// * 更新端点 endname 和服务器 servername 上 svcMeth 的统计。
+// * servername 为 nil（端点没有连接）时只更新端点的统计。
+func (ns *netStats) update(endname interface{}, servername interface{}, svcMeth string, f func(ms *MethodStats)) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	f(lookupStats(ns.ends, endname, svcMeth))
+	if servername != nil {
+		f(lookupStats(ns.servers, servername, svcMeth))
+	}
+}
+
+// * 只更新服务器 servername 上 svcMeth 的统计。
+func (ns *netStats) updateServer(servername interface{}, svcMeth string, f func(ms *MethodStats)) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	f(lookupStats(ns.servers, servername, svcMeth))
+}
+
+func (ns *netStats) snapshot(m map[interface{}]map[string]*MethodStats, who interface{}) map[string]MethodStats {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	res := map[string]MethodStats{}
+	for meth, ms := range m[who] {
+		res[meth] = ms.copy()
+	}
+	return res
+}
+
+// * 获取从端点 endname 发出的调用的各方法统计，按 svcMeth 索引。
+func (rn *Network) EndMethodStats(endname interface{}) map[string]MethodStats {
+	return rn.stats.snapshot(rn.stats.ends, endname)
+}
+
+// * 获取发往服务器 servername 的调用的各方法统计，按 svcMeth 索引。
+func (rn *Network) ServerMethodStats(servername interface{}) map[string]MethodStats {
+	return rn.stats.snapshot(rn.stats.servers, servername)
+}
+
+// * 记录一次调用的开始。
+func (rn *Network) statCall(req reqMsg, servername interface{}) {
+	rn.stats.update(req.endname, servername, req.svcMeth, func(ms *MethodStats) {
+		ms.Calls += 1
+		ms.RequestBytes += int64(len(req.args))
+	})
+}
+
+// * 记录一次重复投递：服务器多收到一次请求，端点的统计不变。
+func (rn *Network) statDuplicate(req reqMsg, servername interface{}) {
+	rn.stats.updateServer(servername, req.svcMeth, func(ms *MethodStats) {
+		ms.Calls += 1
+		ms.Duplicates += 1
+		ms.RequestBytes += int64(len(req.args))
+	})
+}
+
+// * 记录一次没有收到回复的调用，dropped 表示是被网络丢掉的。
+func (rn *Network) statFail(req reqMsg, servername interface{}, dropped bool) {
+	rn.stats.update(req.endname, servername, req.svcMeth, func(ms *MethodStats) {
+		if dropped {
+			ms.Drops += 1
+		}
+		ms.Timeouts += 1
+	})
+}
+
+// * 记录一次送达的回复，latency 是从发出请求到回复送达的时间。
+func (rn *Network) statReply(req reqMsg, servername interface{}, reply replyMsg, latency time.Duration) {
+	rn.stats.update(req.endname, servername, req.svcMeth, func(ms *MethodStats) {
+		ms.ReplyBytes += int64(len(reply.reply))
+		if reply.ok {
+			ms.Latency.observe(latency)
+		} else {
+			//* 服务器拒绝了请求
+			ms.Timeouts += 1
+		}
+	})
+}
@@ -0,0 +1,348 @@
+package labrpc
+
+//
+// 让 Server 通过真实的 TCP 连接提供服务。
+// 连接的每个方向是一个 gob 流，依次是 tcpRequest 或 tcpReply，
+// 同一个流上的类型描述只在第一条消息中发送一次。
+// 编码方式是 gob 时参数和回复也在这个流上，紧跟在帧头后面，
+// 它们的类型描述同样每条连接只发送一次。
+// 其他编码方式的参数和回复是帧里单独编码的 Args 和 Reply，用 tcpRequest.Codec 指定的编码方式。
+// 一条连接同时只承载一个调用，回复的 Seq 必须和请求的相同，否则丢弃这条连接。
+// 服务名和方法名来自网络，不存在的服务或方法直接回复失败，不会让服务器退出。
+//
+
+import (
+	"bufio"
+	"github.com/gyy0727/mit-6.824/labgob"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	tcpDialTimeout = time.Second      //* 建立连接的超时时间
+	tcpMaxIdle     = 4                //* 每个端点连接池中最多保留的空闲连接数
+	tcpCallTimeout = 10 * time.Second //* 一次调用读写的超时时间，服务器写回复也用这个超时
+	tcpStreamCodec = "gob-stream"     //* 参数和回复在连接的 gob 流上，不在帧里
+)
+
+// *TCP 上的请求帧
+type tcpRequest struct {
+	Seq     uint64
+	SvcMeth string
+	Codec   string //* 参数和回复的编码方式，见 labgob.CodecByName；tcpStreamCodec 表示在流上
+	Args    []byte
+}
+
+// *TCP 上的回复帧，流模式下 OK 时回复紧跟在后面
+type tcpReply struct {
+	Seq   uint64
+	OK    bool
+	Reply []byte
+}
+
+// *一条连接和它两个方向上的 gob 流
+type tcpConn struct {
+	c    net.Conn
+	r    *bufio.Reader
+	enc  *labgob.LabEncoder
+	dec  *labgob.LabDecoder
+	peek chan error //* 空闲时后台读的结果
+}
+
+func newTCPConn(c net.Conn) *tcpConn {
+	r := bufio.NewReader(c)
+	return &tcpConn{c: c, r: r, enc: labgob.NewEncoder(c), dec: labgob.NewDecoder(r)}
+}
+
+// * 连接进入空闲：在后台等待对端关闭连接。
+func (tc *tcpConn) idle() {
+	tc.peek = make(chan error, 1)
+	go func() {
+		_, err := tc.r.Peek(1)
+		tc.peek <- err
+	}()
+}
+
+// * 结束空闲，返回连接是否还能用。
+// * 用过期的读超时打断后台读：读到超时说明连接还开着；
+// * 读到 EOF 说明对端已经关闭。空闲连接上不应该有数据，读到数据也当作不能用。
+func (tc *tcpConn) reuse() bool {
+	tc.c.SetReadDeadline(time.Now())
+	err := <-tc.peek
+	tc.c.SetReadDeadline(time.Time{})
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// *通过 TCP 对外提供 Server 的监听器
+type TCPListener struct {
+	mu    sync.Mutex
+	ln    net.Listener
+	rs    *Server
+	conns map[net.Conn]bool
+	done  bool
+}
+
+// * 在 addr（例如 "localhost:0"）上监听，把收到的请求交给 rs 处理。
+func (rs *Server) ListenTCP(addr string) (*TCPListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tl := &TCPListener{ln: ln, rs: rs, conns: map[net.Conn]bool{}}
+	go tl.serve()
+	return tl, nil
+}
+
+// * 实际监听的地址。
+func (tl *TCPListener) Addr() string {
+	return tl.ln.Addr().String()
+}
+
+// * 停止监听，并断开所有连接。
+func (tl *TCPListener) Close() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.done = true
+	for c := range tl.conns {
+		c.Close()
+	}
+	return tl.ln.Close()
+}
+
+func (tl *TCPListener) serve() {
+	for {
+		c, err := tl.ln.Accept()
+		if err != nil {
+			return
+		}
+		tl.mu.Lock()
+		if tl.done {
+			tl.mu.Unlock()
+			c.Close()
+			return
+		}
+		tl.conns[c] = true
+		tl.mu.Unlock()
+		go tl.serveConn(c)
+	}
+}
+
+// * 处理一条连接：逐个读请求，各自在单独的 goroutine 中执行。
+func (tl *TCPListener) serveConn(c net.Conn) {
+	defer func() {
+		tl.mu.Lock()
+		delete(tl.conns, c)
+		tl.mu.Unlock()
+		c.Close()
+	}()
+
+	var wmu sync.Mutex
+	tc := newTCPConn(c)
+	for {
+		var treq tcpRequest
+		if err := tc.dec.Decode(&treq); err != nil {
+			return
+		}
+		codec := labgob.CodecByName(treq.Codec)
+		var argv interface{}
+		if treq.Codec == tcpStreamCodec {
+			//* 参数紧跟在帧头后面，读下一个请求之前先把它读出来；
+			//* 方法不存在时没有地方放，解码到 nil 丢弃
+			codec = labgob.Gob
+			if argsType, ok := tl.rs.argsTypeOf(treq.SvcMeth); ok {
+				argv = reflect.New(argsType).Interface()
+			}
+			if err := tc.dec.Decode(argv); err != nil {
+				return
+			}
+		}
+		go func() {
+			req := reqMsg{}
+			req.endname = c.RemoteAddr().String()
+			req.svcMeth = treq.SvcMeth
+			req.args = treq.Args
+			req.codec = codec
+			req.argv = argv
+			var replyv interface{}
+			if argv != nil {
+				req.replyv = &replyv
+			}
+			rep := replyMsg{false, nil}
+			if req.codec != nil && tl.rs.hasMethod(req.svcMeth) {
+				rep = tl.rs.dispatch(req)
+			}
+
+			wmu.Lock()
+			defer wmu.Unlock()
+			c.SetWriteDeadline(time.Now().Add(tcpCallTimeout))
+			if tc.enc.Encode(tcpReply{treq.Seq, rep.ok, rep.reply}) == nil && rep.ok && req.replyv != nil {
+				tc.enc.Encode(replyv)
+			}
+		}()
+	}
+}
+
+// *通过 TCP 连接发送请求的传输层，带连接池，连接断开后下次调用重新建立
+type tcpTransport struct {
+	addr    string
+	timeout time.Duration //* 一次调用的超时时间
+	mu      sync.Mutex
+	idle    []*tcpConn
+	closed  bool
+}
+
+// * 创建一个连接到 addr 上 TCPListener 的客户端端点。
+func MakeTCPEnd(addr string) *ClientEnd {
+	e := &ClientEnd{}
+	e.endname = addr
+	e.tr = &tcpTransport{addr: addr, timeout: tcpCallTimeout}
+	return e
+}
+
+func (t *tcpTransport) send(req reqMsg) bool {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return false
+	}
+	go func() {
+		req.replyCh <- t.roundTrip(req)
+	}()
+	return true
+}
+
+// * TCP 端点不知道服务器上服务的设置，默认使用 gob。
+func (t *tcpTransport) codecFor(endname interface{}, svcMeth string) labgob.Codec {
+	return labgob.Gob
+}
+
+func (t *tcpTransport) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.dropIdle()
+}
+
+// * 从连接池中取一条连接，跳过已经被对端关闭的，没有空闲连接时新建。
+func (t *tcpTransport) get() (tc *tcpConn, reused bool, err error) {
+	t.mu.Lock()
+	for n := len(t.idle); n > 0; n = len(t.idle) {
+		tc = t.idle[n-1]
+		t.idle = t.idle[:n-1]
+		if tc.reuse() {
+			t.mu.Unlock()
+			return tc, true, nil
+		}
+		tc.c.Close()
+	}
+	t.mu.Unlock()
+	c, err := net.DialTimeout("tcp", t.addr, tcpDialTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+	return newTCPConn(c), false, nil
+}
+
+// * 把用完的连接放回连接池。
+func (t *tcpTransport) put(tc *tcpConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || len(t.idle) >= tcpMaxIdle {
+		tc.c.Close()
+		return
+	}
+	tc.idle()
+	t.idle = append(t.idle, tc)
+}
+
+// * 丢弃所有空闲连接。
+func (t *tcpTransport) dropIdle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tc := range t.idle {
+		tc.c.Close()
+	}
+	t.idle = nil
+}
+
+// * 在一条连接上完成一次请求，参数和回复在帧里。
+func (t *tcpTransport) roundTrip(req reqMsg) replyMsg {
+	treq := tcpRequest{req.seq, req.svcMeth, req.codec.Name(), req.args}
+	trep, ok := t.exchange(req.seq, func(tc *tcpConn) error {
+		return tc.enc.Encode(treq)
+	}, func(tc *tcpConn) (tcpReply, error) {
+		var trep tcpReply
+		err := tc.dec.Decode(&trep)
+		return trep, err
+	})
+	if !ok {
+		return replyMsg{false, nil}
+	}
+	return replyMsg{trep.OK, trep.Reply}
+}
+
+// * 在一条连接上完成一次 gob 编码的调用，参数和回复直接在连接的流上编码和解码，
+// * 连接上已经发送过的类型不再重复发送类型描述。
+func (t *tcpTransport) streamCall(req reqMsg, args interface{}, reply interface{}) bool {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return false
+	}
+	treq := tcpRequest{req.seq, req.svcMeth, tcpStreamCodec, nil}
+	trep, ok := t.exchange(req.seq, func(tc *tcpConn) error {
+		if err := tc.enc.Encode(treq); err != nil {
+			return err
+		}
+		return tc.enc.Encode(args)
+	}, func(tc *tcpConn) (tcpReply, error) {
+		var trep tcpReply
+		if err := tc.dec.Decode(&trep); err != nil || trep.Seq != req.seq || !trep.OK {
+			return trep, err
+		}
+		return trep, tc.dec.Decode(reply)
+	})
+	return ok && trep.OK
+}
+
+// * 在一条连接上完成一次调用：write 写出请求，read 读回回复。
+// * 空闲连接可能已经被服务器关闭（例如服务器重启过），写请求失败时
+// * 丢弃所有空闲连接，换一条新连接重试一次。请求写出之后服务器可能已经
+// * 执行了处理函数，这时读回复失败不再重试，保证每次调用最多执行一次；
+// * 所以服务器重启后的第一次调用可能返回 false，由调用者重试。
+// * 回复的 Seq 和 seq 不同时也丢弃这条连接。
+func (t *tcpTransport) exchange(seq uint64, write func(tc *tcpConn) error, read func(tc *tcpConn) (tcpReply, error)) (tcpReply, bool) {
+	for attempt := 0; attempt < 2; attempt++ {
+		tc, reused, err := t.get()
+		if err != nil {
+			return tcpReply{}, false
+		}
+		tc.c.SetDeadline(time.Now().Add(t.timeout))
+		if err := write(tc); err != nil {
+			tc.c.Close()
+			if reused {
+				t.dropIdle()
+				continue
+			}
+			return tcpReply{}, false
+		}
+		trep, err := read(tc)
+		if err != nil || trep.Seq != seq {
+			//* 连接池里的其他连接很可能也已经失效，下次调用换新连接
+			tc.c.Close()
+			if reused {
+				t.dropIdle()
+			}
+			return tcpReply{}, false
+		}
+		tc.c.SetDeadline(time.Time{})
+		t.put(tc)
+		return trep, true
+	}
+	return tcpReply{}, false
+}
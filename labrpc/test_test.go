@@ -0,0 +1,1338 @@
+package labrpc
+
+import "testing"
+import "strconv"
+import "sync"
+import "strings"
+import "bytes"
+import "encoding/json"
+import "time"
+import "runtime"
+import "math/rand/v2"
+import "net"
+import "io"
+import "github.com/gyy0727/mit-6.824/labgob"
+import "github.com/gyy0727/mit-6.824/labtime"
+
+type JunkArgs struct {
+	X int
+}
+
+type JunkReply struct {
+	X string
+}
+
+type JunkServer struct {
+	mu      sync.Mutex
+	log1    []string
+	log2    []int
+	release chan struct{} //* 关闭后卡在 Handler3 中的调用立即返回
+}
+
+func (js *JunkServer) Handler1(args string, reply *int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.log1 = append(js.log1, args)
+	*reply, _ = strconv.Atoi(args)
+}
+
+func (js *JunkServer) Handler2(args int, reply *string) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.log2 = append(js.log2, args)
+	*reply = "handler2-" + strconv.Itoa(args)
+}
+
+func (js *JunkServer) Handler3(args int, reply *int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	select {
+	case <-time.After(20 * time.Second):
+	case <-js.release:
+	}
+	*reply = -args
+}
+
+// args is a pointer
+func (js *JunkServer) Handler4(args *JunkArgs, reply *JunkReply) {
+	reply.X = "pointer"
+}
+
+// args is a not pointer
+func (js *JunkServer) Handler5(args JunkArgs, reply *JunkReply) {
+	reply.X = "no pointer"
+}
+
+func (js *JunkServer) Handler6(args string, reply *int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	*reply = len(args)
+}
+
+func (js *JunkServer) Handler7(args int, reply *string) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	*reply = strings.Repeat("y", args)
+}
+
+// 建立 n 个节点的全连接网络，ends[i][j] 是节点 i 到服务器 j 的端点。
+func makeCluster(rn *Network, n int) ([][]*ClientEnd, []*JunkServer) {
+	jss := make([]*JunkServer, n)
+	for j := 0; j < n; j++ {
+		jss[j] = &JunkServer{}
+		rs := MakeServer()
+		rs.AddService(MakeService(jss[j]))
+		rn.AddServer(j, rs)
+	}
+	ends := make([][]*ClientEnd, n)
+	for i := 0; i < n; i++ {
+		ends[i] = make([]*ClientEnd, n)
+		for j := 0; j < n; j++ {
+			endname := "end" + strconv.Itoa(i) + "-" + strconv.Itoa(j)
+			ends[i][j] = rn.MakeEnd(endname)
+			rn.Connect(endname, j)
+			rn.SetEndOwner(endname, i)
+			rn.Enable(endname, true)
+		}
+	}
+	return ends, jss
+}
+
+func TestPartition(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, _ := makeCluster(rn, 5)
+
+	call := func(i, j int) bool {
+		reply := ""
+		return ends[i][j].Call("JunkServer.Handler2", 111, &reply)
+	}
+
+	rn.Partition([]interface{}{0, 1}, []interface{}{2, 3, 4})
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			same := (i < 2) == (j < 2)
+			if ok := call(i, j); ok != same {
+				t.Fatalf("%v -> %v: expected %v, got %v", i, j, same, ok)
+			}
+		}
+	}
+
+	rn.Heal()
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			if call(i, j) == false {
+				t.Fatalf("%v -> %v failed after Heal", i, j)
+			}
+		}
+	}
+}
+
+// 单向分区：请求能到达并执行，但回复回不来。
+func TestPartitionOneWay(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, jss := makeCluster(rn, 3)
+
+	rn.PartitionOneWay([]interface{}{2}, []interface{}{0})
+
+	reply := ""
+	if ends[0][2].Call("JunkServer.Handler2", 7, &reply) {
+		t.Fatalf("reply from 2 to 0 should have been dropped")
+	}
+	jss[2].mu.Lock()
+	n := len(jss[2].log2)
+	jss[2].mu.Unlock()
+	if n != 1 {
+		t.Fatalf("request from 0 to 2 should have executed, log2 has %v entries", n)
+	}
+
+	//* 其他节点不受影响。
+	if ends[1][2].Call("JunkServer.Handler2", 8, &reply) == false || reply != "handler2-8" {
+		t.Fatalf("1 -> 2 should succeed")
+	}
+
+	rn.Heal()
+	if ends[0][2].Call("JunkServer.Handler2", 9, &reply) == false {
+		t.Fatalf("0 -> 2 failed after Heal")
+	}
+}
+
+func TestLinkConfig(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, _ := makeCluster(rn, 3)
+
+	//* 只有 0 -> 1 这条链路丢包，其他链路延迟固定 50ms。
+	rn.SetConfig(&NetworkConfig{RequestDelay: FixedDelay(50 * time.Millisecond)})
+	rn.SetLinkConfig(0, 1, &NetworkConfig{RequestDrop: 1})
+
+	reply := ""
+	if ends[0][1].Call("JunkServer.Handler2", 1, &reply) {
+		t.Fatalf("0 -> 1 should have been dropped")
+	}
+
+	t0 := time.Now()
+	if ends[0][2].Call("JunkServer.Handler2", 2, &reply) == false {
+		t.Fatalf("0 -> 2 failed")
+	}
+	if d := time.Since(t0); d < 50*time.Millisecond {
+		t.Fatalf("0 -> 2 took %v, expected at least 50ms", d)
+	}
+
+	rn.SetLinkConfig(0, 1, nil)
+	if ends[0][1].Call("JunkServer.Handler2", 3, &reply) == false {
+		t.Fatalf("0 -> 1 failed after removing link config")
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, _ := makeCluster(rn, 2)
+
+	//* 10KB/s 的链路上发 2KB 的请求，至少需要 200ms。
+	rn.SetLinkConfig(0, 1, &NetworkConfig{Bandwidth: 10000})
+
+	t0 := time.Now()
+	reply := 0
+	if ends[0][1].Call("JunkServer.Handler1", strings.Repeat("1", 2000), &reply) == false {
+		t.Fatalf("call failed")
+	}
+	if d := time.Since(t0); d < 200*time.Millisecond {
+		t.Fatalf("2000 bytes at 10000 B/s took only %v", d)
+	}
+}
+
+// LongReordering 默认的乱序延迟和原来的 200 + rand.Intn(1+rand.Intn(2000)) 毫秒一样。
+func TestReorderDelay(t *testing.T) {
+	d := defaultConfig(true, false, true, 0).ReorderDelay
+	if d != NestedDelay(200*time.Millisecond, 2200*time.Millisecond) {
+		t.Fatalf("wrong default reorder delay %+v", d)
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	var sum time.Duration
+	const n = 10000
+	for i := 0; i < n; i++ {
+		x := d.sample(rng)
+		if x < 200*time.Millisecond || x >= 2200*time.Millisecond {
+			t.Fatalf("delay %v out of range", x)
+		}
+		sum += x
+	}
+	//* 均值约为 200 + 1000/2 = 700ms
+	if mean := sum / n; mean < 650*time.Millisecond || mean > 750*time.Millisecond {
+		t.Fatalf("mean delay %v, expected about 700ms", mean)
+	}
+}
+
+// 同一个种子必须产生同样的丢包决定。
+func TestSeedReplay(t *testing.T) {
+	run := func(seed int64) []bool {
+		rn := MakeNetworkSeed(seed)
+		defer rn.Cleanup()
+		rn.LogSeedOnFailure(t)
+
+		ends, _ := makeCluster(rn, 2)
+		rn.Reliable(false)
+
+		oks := []bool{}
+		for i := 0; i < 50; i++ {
+			reply := ""
+			oks = append(oks, ends[0][1].Call("JunkServer.Handler2", i, &reply))
+		}
+		return oks
+	}
+
+	a := run(42)
+	b := run(42)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("call %v: %v with first run, %v with replay", i, a[i], b[i])
+		}
+	}
+
+	rn := MakeNetworkSeed(7)
+	defer rn.Cleanup()
+	if rn.Seed() != 7 {
+		t.Fatalf("Seed() = %v, expected 7", rn.Seed())
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, jss := makeCluster(rn, 2)
+
+	rn.Duplicate(1)
+	const n = 10
+	for i := 0; i < n; i++ {
+		reply := ""
+		if ends[0][1].Call("JunkServer.Handler2", i, &reply) == false || reply != "handler2-"+strconv.Itoa(i) {
+			t.Fatalf("wrong reply %v", reply)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if got := rn.GetDuplicateCount(); got != n*maxDuplicates {
+		t.Fatalf("expected %v duplicates, got %v", n*maxDuplicates, got)
+	}
+	jss[1].mu.Lock()
+	executed := len(jss[1].log2)
+	jss[1].mu.Unlock()
+	if executed != n*(1+maxDuplicates) {
+		t.Fatalf("expected %v executions, got %v", n*(1+maxDuplicates), executed)
+	}
+
+	//* 重复的副本计入总数和服务器的统计，端点的统计只有真正发出的调用
+	if got := rn.GetTotalCount(); got != n*(1+maxDuplicates) {
+		t.Fatalf("expected total count %v, got %v", n*(1+maxDuplicates), got)
+	}
+	ss := rn.ServerMethodStats(1)["JunkServer.Handler2"]
+	if ss.Calls != n*(1+maxDuplicates) || ss.Duplicates != n*maxDuplicates {
+		t.Fatalf("wrong server stats %+v", ss)
+	}
+	if es := rn.EndMethodStats("end0-1")["JunkServer.Handler2"]; es.Calls != n || es.Duplicates != 0 {
+		t.Fatalf("wrong end stats %+v", es)
+	}
+
+	rn.Duplicate(0)
+	reply := ""
+	ends[0][1].Call("JunkServer.Handler2", 0, &reply)
+	if got := rn.GetDuplicateCount(); got != n*maxDuplicates {
+		t.Fatalf("duplicated after Duplicate(0)")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, _ := makeCluster(rn, 2)
+
+	buf := new(bytes.Buffer)
+	jt := NewJSONTracer(buf)
+	rn.SetTracer(jt)
+
+	reply := ""
+	ends[0][1].Call("JunkServer.Handler2", 111, &reply)
+	rn.Enable("end0-1", false)
+	ends[0][1].Call("JunkServer.Handler2", 222, &reply)
+	rn.SetTracer(nil)
+
+	events := []TraceEvent{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		ev := TraceEvent{}
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("bad trace line: %v", err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %v", len(events))
+	}
+
+	req, rep, dead := events[0], events[1], events[2]
+	if req.Kind != "request" || req.Decision != TraceDelivered || req.From != "0" || req.Server != "1" ||
+		req.SvcMeth != "JunkServer.Handler2" || string(req.Args) != "111" {
+		t.Fatalf("wrong request event %+v", req)
+	}
+	if rep.Kind != "reply" || rep.Decision != TraceDelivered || rep.Bytes == 0 {
+		t.Fatalf("wrong reply event %+v", rep)
+	}
+	if dead.Kind != "request" || dead.Decision != TraceUnreachable || string(dead.Args) != "222" {
+		t.Fatalf("wrong unreachable event %+v", dead)
+	}
+}
+
+// 同一个 Service 通过真实的 TCP 连接提供服务。
+func TestTCP(t *testing.T) {
+	js := &JunkServer{}
+	rs := MakeServer()
+	rs.AddService(MakeService(js))
+
+	tl, err := rs.ListenTCP("localhost:0")
+	if err != nil {
+		t.Fatalf("ListenTCP: %v", err)
+	}
+	addr := tl.Addr()
+
+	e := MakeTCPEnd(addr)
+	defer e.Close()
+
+	reply := ""
+	if e.Call("JunkServer.Handler2", 111, &reply) == false || reply != "handler2-111" {
+		t.Fatalf("wrong reply from Handler2: %v", reply)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := 0
+			if e.Call("JunkServer.Handler1", strconv.Itoa(i), &n) == false || n != i {
+				t.Errorf("wrong reply from Handler1: %v, expected %v", n, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//* 服务器关闭后调用失败，在同一地址重启后自动重连。
+	tl.Close()
+	reply = ""
+	if e.Call("JunkServer.Handler2", 1, &reply) {
+		t.Fatalf("call succeeded after listener closed")
+	}
+	tl, err = rs.ListenTCP(addr)
+	if err != nil {
+		t.Fatalf("ListenTCP again: %v", err)
+	}
+	defer tl.Close()
+	//* 连接池里旧的连接可能让第一次调用失败，但不会重复执行
+	ok := false
+	for i := 0; i < 2 && !ok; i++ {
+		ok = e.Call("JunkServer.Handler2", 2, &reply)
+	}
+	if ok == false || reply != "handler2-2" {
+		t.Fatalf("call failed after restart")
+	}
+
+	if rs.GetCount() != 22 {
+		t.Fatalf("wrong GetCount() %v, expected 22", rs.GetCount())
+	}
+}
+
+// 来自网络的服务名和方法名不可信：格式错误或不存在时调用失败，服务器继续工作。
+// 处理函数卡住时，调用在超时后返回 false。
+func TestTCPBadMethod(t *testing.T) {
+	js := &JunkServer{release: make(chan struct{})}
+	defer close(js.release)
+	rs := MakeServer()
+	rs.AddService(MakeService(js))
+
+	tl, err := rs.ListenTCP("localhost:0")
+	if err != nil {
+		t.Fatalf("ListenTCP: %v", err)
+	}
+	defer tl.Close()
+
+	e := MakeTCPEnd(tl.Addr())
+	defer e.Close()
+
+	for _, svcMeth := range []string{"Handler2", "", ".", "NoService.Handler2", "JunkServer.NoMethod", "JunkServer."} {
+		reply := ""
+		if e.Call(svcMeth, 111, &reply) {
+			t.Fatalf("call to %q succeeded", svcMeth)
+		}
+	}
+	reply := ""
+	if e.Call("JunkServer.Handler2", 111, &reply) == false || reply != "handler2-111" {
+		t.Fatalf("server stopped working after bad calls: %q", reply)
+	}
+
+	e.tr.(*tcpTransport).timeout = 200 * time.Millisecond
+	t0 := time.Now()
+	n := 0
+	if e.Call("JunkServer.Handler3", 1, &n) {
+		t.Fatalf("Handler3 returned before the timeout")
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Fatalf("call took %v with a 200ms timeout", d)
+	}
+}
+
+// *记录经过代理的字节，拷贝和测试在不同的 goroutine 中
+type wireBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (wb *wireBuffer) Write(p []byte) (int, error) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.buf.Write(p)
+}
+
+func (wb *wireBuffer) count(s string) int {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return bytes.Count(wb.buf.Bytes(), []byte(s))
+}
+
+// gob 编码的参数和回复在连接的流上，类型描述每条连接只发送一次。
+func TestTCPStream(t *testing.T) {
+	rs := MakeServer()
+	rs.AddService(MakeService(&JunkServer{}))
+	tl, err := rs.ListenTCP("localhost:0")
+	if err != nil {
+		t.Fatalf("ListenTCP: %v", err)
+	}
+	defer tl.Close()
+
+	//* 在客户端和服务器之间加一个记录字节的代理
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	var up, down wireBuffer
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s, err := net.Dial("tcp", tl.Addr())
+			if err != nil {
+				c.Close()
+				return
+			}
+			go func() {
+				io.Copy(io.MultiWriter(s, &up), c)
+				s.Close()
+			}()
+			go func() {
+				io.Copy(io.MultiWriter(c, &down), s)
+				c.Close()
+			}()
+		}
+	}()
+
+	e := MakeTCPEnd(ln.Addr().String())
+	defer e.Close()
+	for i := 0; i < 3; i++ {
+		reply := JunkReply{}
+		if e.Call("JunkServer.Handler4", &JunkArgs{i}, &reply) == false || reply.X != "pointer" {
+			t.Fatalf("wrong reply from Handler4: %q", reply.X)
+		}
+	}
+	if n := up.count("JunkArgs"); n != 1 {
+		t.Fatalf("JunkArgs type sent %v times, expected once", n)
+	}
+	if n := down.count("JunkReply"); n != 1 {
+		t.Fatalf("JunkReply type sent %v times, expected once", n)
+	}
+}
+
+type BlockServer struct {
+	release chan struct{}
+}
+
+func (bs *BlockServer) Wait(args int, reply *int) {
+	<-bs.release
+	*reply = args
+}
+
+func TestLimits(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	bs := &BlockServer{release: make(chan struct{})}
+	rs := MakeServer()
+	rs.AddService(MakeService(bs))
+	rs.SetLimits(Limits{MaxInFlight: 1, MaxQueue: 1})
+	rn.AddServer("server", rs)
+
+	results := make(chan bool, 3)
+	for i := 0; i < 3; i++ {
+		e := rn.MakeEnd(i)
+		rn.Connect(i, "server")
+		rn.Enable(i, true)
+		go func(i int) {
+			reply := 0
+			results <- e.Call("BlockServer.Wait", i, &reply)
+		}(i)
+	}
+
+	//* 一个在执行，一个在排队，第三个被拒绝。
+	if ok := <-results; ok {
+		t.Fatalf("third request should have been rejected")
+	}
+	st := rs.Stats()
+	if st.InFlight != 1 || st.Queued != 1 || st.Rejected != 1 || st.Count != 3 {
+		t.Fatalf("wrong stats %+v", st)
+	}
+
+	close(bs.release)
+	for i := 0; i < 2; i++ {
+		if ok := <-results; ok == false {
+			t.Fatalf("queued request failed")
+		}
+	}
+	st = rs.Stats()
+	if st.InFlight != 0 || st.Queued != 0 || st.MaxQueued != 1 {
+		t.Fatalf("wrong stats after draining %+v", st)
+	}
+	if _, ok := st.Services["BlockServer"]; ok == false {
+		t.Fatalf("missing per-service stats")
+	}
+}
+
+// 饱和的服务只在自己的队列里等待，不占用服务器的名额；
+// 提高限制时立即放行排队的请求；删除服务器时排队的请求失败。
+func TestLimitsQueue(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	bs := &BlockServer{release: make(chan struct{})}
+	bsvc := MakeService(bs)
+	bsvc.SetLimits(Limits{MaxInFlight: 1, MaxQueue: 10})
+	rs := MakeServer()
+	rs.AddService(bsvc)
+	rs.AddService(MakeService(&JunkServer{}))
+	rs.SetLimits(Limits{MaxInFlight: 2, MaxQueue: 5})
+	rn.AddServer("server", rs)
+
+	call := func(name string, svcMeth string, args int) chan bool {
+		e := rn.MakeEnd(name)
+		rn.Connect(name, "server")
+		rn.Enable(name, true)
+		ch := make(chan bool, 1)
+		go func() {
+			reply := 0
+			ch <- e.Call(svcMeth, args, &reply)
+		}()
+		return ch
+	}
+	waitFor := func(what string, f func(st ServerStats) bool) {
+		for i := 0; i < 100; i++ {
+			if f(rs.Stats()) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%v: stats %+v", what, rs.Stats())
+	}
+
+	blocked := []chan bool{}
+	for i := 0; i < 4; i++ {
+		blocked = append(blocked, call("b"+strconv.Itoa(i), "BlockServer.Wait", i))
+	}
+	waitFor("three queued in the service", func(st ServerStats) bool {
+		return st.InFlight == 1 && st.Services["BlockServer"].Queued == 3
+	})
+
+	//* 服务器还有一个名额，JunkServer 不受影响
+	reply := ""
+	e := rn.MakeEnd("junk")
+	rn.Connect("junk", "server")
+	rn.Enable("junk", true)
+	if e.Call("JunkServer.Handler2", 1, &reply) == false || reply != "handler2-1" {
+		t.Fatalf("JunkServer blocked behind a saturated service")
+	}
+
+	//* 提高服务的限制，排队的请求立即离开服务的队列，超出服务器限制的在服务器上排队
+	bsvc.SetLimits(Limits{MaxInFlight: 3, MaxQueue: 10})
+	waitFor("service queue drained", func(st ServerStats) bool {
+		return st.InFlight == 2 && st.Queued == 1 &&
+			st.Services["BlockServer"].InFlight == 3 && st.Services["BlockServer"].Queued == 1
+	})
+
+	//* 删除服务器，排队的请求失败，不再等待
+	rn.DeleteServer("server")
+	waitFor("queues failed", func(st ServerStats) bool {
+		return st.Queued == 0 && st.Services["BlockServer"].Queued == 0
+	})
+	close(bs.release)
+	for _, ch := range blocked {
+		if <-ch {
+			t.Fatalf("call succeeded after DeleteServer")
+		}
+	}
+}
+
+func TestMethodStats(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, _ := makeCluster(rn, 2)
+
+	for i := 0; i < 10; i++ {
+		reply := ""
+		ends[0][1].Call("JunkServer.Handler2", i, &reply)
+	}
+	for i := 0; i < 5; i++ {
+		reply := 0
+		ends[1][1].Call("JunkServer.Handler1", "7", &reply)
+	}
+	rn.Enable("end0-1", false)
+	reply := ""
+	ends[0][1].Call("JunkServer.Handler2", 0, &reply)
+
+	es := rn.EndMethodStats("end0-1")["JunkServer.Handler2"]
+	if es.Calls != 11 || es.Timeouts != 1 || es.Drops != 0 || es.Latency.Count != 10 {
+		t.Fatalf("wrong end stats %+v", es)
+	}
+	ss := rn.ServerMethodStats(1)
+	if ss["JunkServer.Handler2"].Calls != 11 || ss["JunkServer.Handler1"].Calls != 5 {
+		t.Fatalf("wrong server stats %+v", ss)
+	}
+
+	var total int64
+	for _, ms := range ss {
+		total += ms.RequestBytes + ms.ReplyBytes
+	}
+	if total != rn.GetTotalBytes() {
+		t.Fatalf("per-method bytes %v != total bytes %v", total, rn.GetTotalBytes())
+	}
+
+	rn.Enable("end0-1", true)
+	rn.Reliable(false)
+	for i := 0; i < 100; i++ {
+		reply := ""
+		ends[0][1].Call("JunkServer.Handler2", i, &reply)
+	}
+	es = rn.EndMethodStats("end0-1")["JunkServer.Handler2"]
+	if es.Drops == 0 || es.Drops > es.Timeouts {
+		t.Fatalf("wrong drop accounting %+v", es)
+	}
+	if q := es.Latency.Quantile(0.99); q < es.Latency.Mean() {
+		t.Fatalf("p99 %v below mean %v", q, es.Latency.Mean())
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, _ := makeCluster(rn, 3)
+
+	//* 服务器 2 丢掉所有 Handler2，Handler1 的回复加 1。
+	rs2 := MakeServer()
+	rs2.AddService(MakeService(&JunkServer{}))
+	rn.AddServer(2, rs2)
+	rs2.Use(func(svcMeth string, args interface{}, reply interface{}, next Handler) bool {
+		if svcMeth == "JunkServer.Handler2" {
+			return false
+		}
+		ok := next(svcMeth, args, reply)
+		*reply.(*int) += 1
+		return ok
+	})
+
+	reply := ""
+	if ends[0][2].Call("JunkServer.Handler2", 1, &reply) {
+		t.Fatalf("Handler2 on server 2 should fail")
+	}
+	if ends[0][1].Call("JunkServer.Handler2", 1, &reply) == false {
+		t.Fatalf("Handler2 on server 1 should succeed")
+	}
+	n := 0
+	if ends[0][2].Call("JunkServer.Handler1", "41", &n) == false || n != 42 {
+		t.Fatalf("expected 42 from intercepted Handler1, got %v", n)
+	}
+
+	//* 客户端拦截器：改写参数，并对负数直接返回失败。
+	calls := 0
+	ends[1][0].Use(func(svcMeth string, args interface{}, reply interface{}, next Invoker) bool {
+		calls++
+		if args.(int) < 0 {
+			return false
+		}
+		return next(svcMeth, args.(int)*10, reply)
+	})
+	reply = ""
+	if ends[1][0].Call("JunkServer.Handler2", 5, &reply) == false || reply != "handler2-50" {
+		t.Fatalf("wrong reply %v", reply)
+	}
+	if ends[1][0].Call("JunkServer.Handler2", -1, &reply) {
+		t.Fatalf("short-circuited call should fail")
+	}
+	if calls != 2 || rn.GetCount(0) != 1 {
+		t.Fatalf("calls %v, server 0 count %v", calls, rn.GetCount(0))
+	}
+}
+
+func TestFaultRules(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	ends, jss := makeCluster(rn, 3)
+
+	call := func(i, j int) bool {
+		reply := ""
+		return ends[i][j].Call("JunkServer.Handler2", 1, &reply)
+	}
+
+	//* 丢掉服务器 1 接下来的 3 个 Handler2 回复，请求本身照常执行。
+	rn.AddRule(FaultRule{SvcMeth: "JunkServer.Handler2", To: 1, Phase: OnReply, Count: 3})
+	for k := 0; k < 3; k++ {
+		if call(0, 1) {
+			t.Fatalf("reply %v from server 1 should have been dropped", k)
+		}
+	}
+	if call(0, 1) == false || call(0, 2) == false {
+		t.Fatalf("rule should have expired after 3 drops")
+	}
+	jss[1].mu.Lock()
+	executed := len(jss[1].log2)
+	jss[1].mu.Unlock()
+	if executed != 4 {
+		t.Fatalf("expected 4 executions on server 1, got %v", executed)
+	}
+
+	//* 节点 2 发出的每第 5 个请求失败。
+	id := rn.AddRule(FaultRule{From: 2, Every: 5})
+	failed := 0
+	for k := 0; k < 20; k++ {
+		if call(2, 0) == false {
+			failed++
+		}
+	}
+	if failed != 4 {
+		t.Fatalf("expected 4 of 20 calls to fail, got %v", failed)
+	}
+	rn.RemoveRule(id)
+
+	//* 推迟发往服务器 0 的请求。
+	rn.AddRule(FaultRule{To: 0, Action: FaultDelay, Delay: 100 * time.Millisecond})
+	t0 := time.Now()
+	if call(1, 0) == false || time.Since(t0) < 100*time.Millisecond {
+		t.Fatalf("call to server 0 should succeed after 100ms")
+	}
+	t0 = time.Now()
+	if call(1, 2) == false || time.Since(t0) > 50*time.Millisecond {
+		t.Fatalf("call to server 2 should not be delayed")
+	}
+	rn.ClearRules()
+}
+
+func TestVirtualClock(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	clock := labtime.NewVirtualClock(time.Unix(0, 0))
+	rn.SetClock(clock)
+	stop := clock.AutoAdvance(time.Millisecond)
+	defer stop()
+
+	ends, _ := makeCluster(rn, 2)
+	rn.SetConfig(&NetworkConfig{RequestDelay: FixedDelay(5 * time.Second)})
+
+	t0 := time.Now()
+	reply := ""
+	if ends[0][1].Call("JunkServer.Handler2", 1, &reply) == false {
+		t.Fatalf("call failed")
+	}
+	if v := clock.Now().Sub(time.Unix(0, 0)); v < 5*time.Second {
+		t.Fatalf("virtual time only advanced %v", v)
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Fatalf("5s virtual delay took %v of real time", d)
+	}
+
+	//* 完成的 RPC 不留下定时器
+	stop()
+	if n := clock.Pending(); n != 0 {
+		t.Fatalf("%v timers left after the RPC finished", n)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	bytesFor := map[string]int64{}
+	for _, c := range []labgob.Codec{labgob.Gob, labgob.JSON, labgob.Binary} {
+		rn := MakeNetwork()
+		rn.SetCodec(c)
+		ends, _ := makeCluster(rn, 1)
+		for i := 0; i < 10; i++ {
+			reply := ""
+			if ends[0][0].Call("JunkServer.Handler2", 1000+i, &reply) == false || reply != "handler2-"+strconv.Itoa(1000+i) {
+				t.Fatalf("%v: wrong reply %q", c.Name(), reply)
+			}
+		}
+		bytesFor[c.Name()] = rn.GetTotalBytes()
+		rn.Cleanup()
+	}
+	if bytesFor["binary"] >= bytesFor["gob"] {
+		t.Fatalf("binary codec sent %v bytes, gob %v", bytesFor["binary"], bytesFor["gob"])
+	}
+
+	//* 服务上的设置优先于网络的设置，端点上的设置优先于服务的设置。
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+	js := &JunkServer{}
+	svc := MakeService(js)
+	svc.SetCodec(labgob.JSON)
+	rs := MakeServer()
+	rs.AddService(svc)
+	rn.AddServer("s", rs)
+	e := rn.MakeEnd("e")
+	rn.Connect("e", "s")
+	rn.Enable("e", true)
+
+	n := 0
+	e.Call("JunkServer.Handler1", "12345", &n)
+	//* GetTotalBytes 包括请求和回复
+	size := func(c labgob.Codec) int64 {
+		a, _ := c.Marshal("12345")
+		r, _ := c.Marshal(12345)
+		return int64(len(a) + len(r))
+	}
+	if n != 12345 || rn.GetTotalBytes() != size(labgob.JSON) {
+		t.Fatalf("service codec not used: reply %v, %v bytes, expected %v", n, rn.GetTotalBytes(), size(labgob.JSON))
+	}
+	e.SetCodec(labgob.Binary)
+	n = 0
+	e.Call("JunkServer.Handler1", "12345", &n)
+	if n != 12345 || rn.GetTotalBytes() != size(labgob.JSON)+size(labgob.Binary) {
+		t.Fatalf("end codec not used: %v bytes", rn.GetTotalBytes())
+	}
+
+	//* TCP 帧里带着编码方式的名字。
+	tl, err := rs.ListenTCP("localhost:0")
+	if err != nil {
+		t.Fatalf("ListenTCP: %v", err)
+	}
+	defer tl.Close()
+	te := MakeTCPEnd(tl.Addr())
+	defer te.Close()
+	te.SetCodec(labgob.Binary)
+	reply := ""
+	if te.Call("JunkServer.Handler2", 7, &reply) == false || reply != "handler2-7" {
+		t.Fatalf("wrong reply over TCP with binary codec: %q", reply)
+	}
+}
+
+// 建立只有一个端点 end1-99 和一个服务器 server99 的网络，端点已连接但没有启用。
+func makeJunk(rn *Network) (*ClientEnd, *JunkServer) {
+	e := rn.MakeEnd("end1-99")
+	js := &JunkServer{}
+	rs := MakeServer()
+	rs.AddService(MakeService(js))
+	rn.AddServer("server99", rs)
+	rn.Connect("end1-99", "server99")
+	return e, js
+}
+
+func TestBasic(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e, _ := makeJunk(rn)
+	rn.Enable("end1-99", true)
+
+	{
+		reply := ""
+		e.Call("JunkServer.Handler2", 111, &reply)
+		if reply != "handler2-111" {
+			t.Fatalf("wrong reply from Handler2")
+		}
+	}
+
+	{
+		reply := 0
+		e.Call("JunkServer.Handler1", "9099", &reply)
+		if reply != 9099 {
+			t.Fatalf("wrong reply from Handler1")
+		}
+	}
+}
+
+func TestTypes(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e, _ := makeJunk(rn)
+	rn.Enable("end1-99", true)
+
+	{
+		var args JunkArgs
+		var reply JunkReply
+		// args must match type (pointer or not) of handler.
+		e.Call("JunkServer.Handler4", &args, &reply)
+		if reply.X != "pointer" {
+			t.Fatalf("wrong reply from Handler4")
+		}
+	}
+
+	{
+		var args JunkArgs
+		var reply JunkReply
+		// args must match type (pointer or not) of handler.
+		e.Call("JunkServer.Handler5", args, &reply)
+		if reply.X != "no pointer" {
+			t.Fatalf("wrong reply from Handler5")
+		}
+	}
+}
+
+// does net.Enable(endname, false) really disconnect a client?
+func TestDisconnect(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e, _ := makeJunk(rn)
+
+	{
+		reply := ""
+		if e.Call("JunkServer.Handler2", 111, &reply) || reply != "" {
+			t.Fatalf("unexpected reply from Handler2")
+		}
+	}
+
+	rn.Enable("end1-99", true)
+
+	{
+		reply := 0
+		e.Call("JunkServer.Handler1", "9099", &reply)
+		if reply != 9099 {
+			t.Fatalf("wrong reply from Handler1")
+		}
+	}
+}
+
+// test net.GetCount()
+func TestCounts(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e := rn.MakeEnd("end1-99")
+	js := &JunkServer{}
+	rs := MakeServer()
+	rs.AddService(MakeService(js))
+	rn.AddServer(99, rs)
+	rn.Connect("end1-99", 99)
+	rn.Enable("end1-99", true)
+
+	for i := 0; i < 17; i++ {
+		reply := ""
+		e.Call("JunkServer.Handler2", i, &reply)
+		wanted := "handler2-" + strconv.Itoa(i)
+		if reply != wanted {
+			t.Fatalf("wrong reply %v from Handler1, expecting %v", reply, wanted)
+		}
+	}
+
+	if n := rn.GetCount(99); n != 17 {
+		t.Fatalf("wrong GetCount() %v, expected 17", n)
+	}
+	if n := rs.GetCount(); n != 17 {
+		t.Fatalf("wrong Server.GetCount() %v, expected 17", n)
+	}
+}
+
+// test RPCs from concurrent ClientEnds
+func TestConcurrentMany(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	js := &JunkServer{}
+	rs := MakeServer()
+	rs.AddService(MakeService(js))
+	rn.AddServer(1000, rs)
+
+	ch := make(chan int)
+
+	nclients := 20
+	nrpcs := 10
+	for ii := 0; ii < nclients; ii++ {
+		go func(i int) {
+			n := 0
+			defer func() { ch <- n }()
+
+			e := rn.MakeEnd(i)
+			rn.Connect(i, 1000)
+			rn.Enable(i, true)
+
+			for j := 0; j < nrpcs; j++ {
+				arg := i*100 + j
+				reply := ""
+				e.Call("JunkServer.Handler2", arg, &reply)
+				wanted := "handler2-" + strconv.Itoa(arg)
+				if reply != wanted {
+					t.Errorf("wrong reply %v from Handler2, expecting %v", reply, wanted)
+				}
+				n += 1
+			}
+		}(ii)
+	}
+
+	total := 0
+	for ii := 0; ii < nclients; ii++ {
+		x := <-ch
+		total += x
+	}
+
+	if total != nclients*nrpcs {
+		t.Fatalf("wrong number of RPCs completed, got %v, expected %v", total, nclients*nrpcs)
+	}
+
+	n := rn.GetCount(1000)
+	if n != total {
+		t.Fatalf("wrong GetCount() %v, expected %v", n, total)
+	}
+}
+
+// test unreliable
+func TestUnreliable(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+	defer rn.LogSeedOnFailure(t)
+	rn.Reliable(false)
+
+	js := &JunkServer{}
+	rs := MakeServer()
+	rs.AddService(MakeService(js))
+	rn.AddServer(1000, rs)
+
+	ch := make(chan int)
+
+	nclients := 300
+	for ii := 0; ii < nclients; ii++ {
+		go func(i int) {
+			n := 0
+			defer func() { ch <- n }()
+
+			e := rn.MakeEnd(i)
+			rn.Connect(i, 1000)
+			rn.Enable(i, true)
+
+			arg := 100 + i
+			reply := ""
+			ok := e.Call("JunkServer.Handler2", arg, &reply)
+			if ok {
+				wanted := "handler2-" + strconv.Itoa(arg)
+				if reply != wanted {
+					t.Errorf("wrong reply %v from Handler2, expecting %v", reply, wanted)
+				}
+				n += 1
+			}
+		}(ii)
+	}
+
+	total := 0
+	for ii := 0; ii < nclients; ii++ {
+		x := <-ch
+		total += x
+	}
+
+	if total == nclients || total == 0 {
+		t.Fatalf("all RPCs succeeded despite unreliable")
+	}
+
+	//* 请求和回复各丢 10%，大约 81% 的调用成功
+	if ratio := float64(total) / float64(nclients); ratio < 0.65 || ratio > 0.95 {
+		t.Fatalf("%v of RPCs succeeded, expected about 0.81", ratio)
+	}
+}
+
+// test concurrent RPCs from a single ClientEnd
+func TestConcurrentOne(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e, js := makeJunk(rn)
+	rn.Enable("end1-99", true)
+
+	ch := make(chan int)
+
+	nrpcs := 20
+	for ii := 0; ii < nrpcs; ii++ {
+		go func(i int) {
+			n := 0
+			defer func() { ch <- n }()
+
+			arg := 100 + i
+			reply := ""
+			e.Call("JunkServer.Handler2", arg, &reply)
+			wanted := "handler2-" + strconv.Itoa(arg)
+			if reply != wanted {
+				t.Errorf("wrong reply %v from Handler2, expecting %v", reply, wanted)
+			}
+			n += 1
+		}(ii)
+	}
+
+	total := 0
+	for ii := 0; ii < nrpcs; ii++ {
+		x := <-ch
+		total += x
+	}
+
+	if total != nrpcs {
+		t.Fatalf("wrong number of RPCs completed, got %v, expected %v", total, nrpcs)
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if len(js.log2) != nrpcs {
+		t.Fatalf("wrong number of RPCs delivered")
+	}
+
+	n := rn.GetCount("server99")
+	if n != total {
+		t.Fatalf("wrong GetCount() %v, expected %v", n, total)
+	}
+}
+
+// regression: an RPC that's delayed during Enabled=false
+// should not delay subsequent RPCs (e.g. after Enabled=true).
+func TestRegression1(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e, js := makeJunk(rn)
+
+	// start some RPCs while the ClientEnd is disabled.
+	// they'll be delayed.
+	rn.Enable("end1-99", false)
+	ch := make(chan bool)
+	nrpcs := 20
+	for ii := 0; ii < nrpcs; ii++ {
+		go func(i int) {
+			ok := false
+			defer func() { ch <- ok }()
+
+			arg := 100 + i
+			reply := ""
+			// this call ought to return false.
+			e.Call("JunkServer.Handler2", arg, &reply)
+			ok = true
+		}(ii)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// now enable the ClientEnd and check that an RPC completes quickly.
+	t0 := time.Now()
+	rn.Enable("end1-99", true)
+	{
+		arg := 99
+		reply := ""
+		e.Call("JunkServer.Handler2", arg, &reply)
+		wanted := "handler2-" + strconv.Itoa(arg)
+		if reply != wanted {
+			t.Fatalf("wrong reply %v from Handler2, expecting %v", reply, wanted)
+		}
+	}
+	dur := time.Since(t0).Seconds()
+
+	if dur > 0.03 {
+		t.Fatalf("RPC took too long (%v) after Enable", dur)
+	}
+
+	for ii := 0; ii < nrpcs; ii++ {
+		<-ch
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if len(js.log2) != 1 {
+		t.Fatalf("wrong number (%v) of RPCs delivered, expected 1", len(js.log2))
+	}
+
+	n := rn.GetCount("server99")
+	if n != 1 {
+		t.Fatalf("wrong GetCount() %v, expected %v", n, 1)
+	}
+}
+
+// if an RPC is stuck in a server, and the server
+// is killed with DeleteServer(), does the RPC
+// get un-stuck?
+func TestKilled(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e, js := makeJunk(rn)
+	rn.Enable("end1-99", true)
+	js.release = make(chan struct{})
+	defer close(js.release) //* 测试结束后不让 Handler3 继续卡 20 秒
+
+	doneCh := make(chan bool)
+	go func() {
+		reply := 0
+		ok := e.Call("JunkServer.Handler3", 99, &reply)
+		doneCh <- ok
+	}()
+
+	time.Sleep(1000 * time.Millisecond)
+
+	select {
+	case <-doneCh:
+		t.Fatalf("Handler3 should not have returned yet")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	rn.DeleteServer("server99")
+
+	select {
+	case x := <-doneCh:
+		if x != false {
+			t.Fatalf("Handler3 returned successfully despite DeleteServer()")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("Handler3 should return after DeleteServer()")
+	}
+}
+
+// GetTotalBytes() counts the encoded request and reply of every RPC.
+func TestBytes(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e, _ := makeJunk(rn)
+	rn.Enable("end1-99", true)
+
+	//* 大请求，小回复
+	for i := 0; i < 17; i++ {
+		args := strings.Repeat("x", 128+i*1000)
+		reply := 0
+		e.Call("JunkServer.Handler6", args, &reply)
+		if reply != len(args) {
+			t.Fatalf("wrong reply %v from Handler6, expecting %v", reply, len(args))
+		}
+	}
+
+	n := rn.GetTotalBytes()
+	//* 17 个请求共 17*128 + 136000 字节的负载
+	if n < 138176 || n > 138176+17*50 {
+		t.Fatalf("wrong GetTotalBytes() %v after large requests", n)
+	}
+
+	//* 小请求，大回复
+	for i := 0; i < 17; i++ {
+		reply := ""
+		e.Call("JunkServer.Handler7", 128+i*1000, &reply)
+		if len(reply) != 128+i*1000 {
+			t.Fatalf("wrong reply length %v from Handler7, expecting %v", len(reply), 128+i*1000)
+		}
+	}
+
+	n2 := rn.GetTotalBytes() - n
+	if n2 < 138176 || n2 > 138176+17*50 {
+		t.Fatalf("wrong GetTotalBytes() %v after large replies", n2)
+	}
+
+	if st := rn.ServerMethodStats("server99"); st["JunkServer.Handler6"].RequestBytes < 138176 ||
+		st["JunkServer.Handler7"].ReplyBytes < 138176 {
+		t.Fatalf("per-method byte counts do not match GetTotalBytes(): %+v", st)
+	}
+}
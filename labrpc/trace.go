@@ -0,0 +1,147 @@
+package labrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// *跟踪记录中消息的去向
+const (
+	TraceDelivered   = "delivered"   //* 送达
+	TraceDelayed     = "delayed"     //* 被推迟后送达（乱序）
+	TraceDropped     = "dropped"     //* 被随机丢弃
+	TraceUnreachable = "unreachable" //* 端点被禁用、没有连接或被分区，请求到不了服务器
+	TracePartitioned = "partitioned" //* 单向分区，回复回不到端点
+	TraceDead        = "dead"        //* 服务器在处理期间被删除
+	TraceDuplicated  = "duplicated"  //* 请求的一个重复副本，或者重复副本被执行后丢弃的回复
+)
+
+// *一条 RPC 跟踪记录，对应一个请求或一个回复的去向
+type TraceEvent struct {
+	Time     time.Time       `json:"time"` //* 消息发出的时刻，到达时刻是 Time+Delay
+	Kind     string          `json:"kind"` //* "request" 或 "reply"
+	End      string          `json:"end"`
+	From     string          `json:"from"` //* 端点所属的节点
+	Server   string          `json:"server"`
+	SvcMeth  string          `json:"svcMeth"`
+	Seq      uint64          `json:"seq"` //* 该端点上的第几次调用
+	Bytes    int             `json:"bytes"`
+	Decision string          `json:"decision"`
+	Delay    time.Duration   `json:"delay,omitempty"`
+	Args     json.RawMessage `json:"args,omitempty"` //* 解码后的参数，只出现在请求里
+}
+
+// *接收跟踪记录，Trace 可能被并发调用
+type Tracer interface {
+	Trace(ev *TraceEvent)
+}
+
+// *把跟踪记录逐行写成 JSON（JSONL）
+type JSONTracer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// * 创建写到 w 的 JSONTracer。
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+// * 创建写到文件 path 的 JSONTracer，用完后需要 Close。
+func CreateTraceFile(path string) (*JSONTracer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	jt := NewJSONTracer(f)
+	jt.c = f
+	return jt, nil
+}
+
+func (jt *JSONTracer) Trace(ev *TraceEvent) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	jt.enc.Encode(ev)
+}
+
+// * 关闭底层文件（如果有）。
+func (jt *JSONTracer) Close() error {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	if jt.c == nil {
+		return nil
+	}
+	return jt.c.Close()
+}
+
+// * 设置网络的跟踪器，nil 表示关闭跟踪。
+func (rn *Network) SetTracer(tr Tracer) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.tracer = tr
+}
+
+// * 记录一次请求的去向，请求在 sent 时刻发出，经过 delay 到达。
+func (rn *Network) traceRequest(req reqMsg, node interface{}, servername interface{}, decision string, sent time.Time, delay time.Duration) {
+	rn.mu.Lock()
+	tr := rn.tracer
+	rn.mu.Unlock()
+	if tr == nil {
+		return
+	}
+	ev := newTraceEvent("request", req, node, servername, len(req.args), decision, sent, delay)
+	ev.Args = decodeArgs(req)
+	tr.Trace(ev)
+}
+
+// * 记录一次回复的去向，回复现在发出，经过 delay 到达。
+func (rn *Network) traceReply(req reqMsg, node interface{}, servername interface{}, n int, decision string, delay time.Duration) {
+	rn.mu.Lock()
+	tr := rn.tracer
+	now := rn.clock.Now()
+	rn.mu.Unlock()
+	if tr == nil {
+		return
+	}
+	tr.Trace(newTraceEvent("reply", req, node, servername, n, decision, now, delay))
+}
+
+func newTraceEvent(kind string, req reqMsg, node interface{}, servername interface{}, n int, decision string, sent time.Time, delay time.Duration) *TraceEvent {
+	return &TraceEvent{
+		Time:     sent,
+		Kind:     kind,
+		End:      fmt.Sprint(req.endname),
+		From:     fmt.Sprint(node),
+		Server:   fmt.Sprint(servername),
+		SvcMeth:  req.svcMeth,
+		Seq:      req.seq,
+		Bytes:    n,
+		Decision: decision,
+		Delay:    delay,
+	}
+}
+
+// * 用请求的编码方式解码参数并转成 JSON。
+// * 不能表示成 JSON 的参数（例如以结构体为键的 map）退化为 %+v 字符串。
+func decodeArgs(req reqMsg) json.RawMessage {
+	if req.argsType == nil {
+		return nil
+	}
+	args := reflect.New(req.argsType)
+	if err := req.codec.Unmarshal(req.args, args.Interface()); err != nil {
+		b, _ := json.Marshal("decode error: " + err.Error())
+		return b
+	}
+	b, err := json.Marshal(args.Elem().Interface())
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%+v", args.Elem().Interface()))
+	}
+	return b
+}
@@ -0,0 +1,215 @@
+package labtime
+
+//
+// 可替换的时钟。
+// 目前只有 labrpc 使用它：网络延迟、乱序、不可达时的等待和检查服务器是否已死亡的定时器
+// 都通过 Network.SetClock 设置的 Clock 计时。测试中换成 VirtualClock 后，
+// 时间由 Advance 或 AutoAdvance 推进，一个 7 秒的延迟只需要几毫秒的真实时间。
+//
+// 没有提供的部分：
+//   - raft 包还没有选举超时和心跳的代码，Raft 的定时器还没有接到 Clock 上，
+//     实现时应该通过 Clock 计时，而不是直接调用 time 包；
+//   - VirtualClock 不是确定性的调度器。它保证定时器按 (到期时间, 创建顺序) 依次触发，
+//     但各个 goroutine 的调度仍然由 Go 运行时决定；AutoAdvance 按真实时间的节拍推进，
+//     不知道还有没有 goroutine 在运行，idle 太小时结果可能不同。
+//     需要可重复的定时器顺序时，由测试自己调用 Advance。
+//
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// *时钟
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// *由 AfterFunc 返回的定时器
+type Timer interface {
+	//* 取消定时器，返回 false 表示它已经触发或已被取消
+	Stop() bool
+}
+
+// *真实时钟，直接使用 time 包
+type realClock struct{}
+
+// *真实时钟
+var Real Clock = realClock{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// *虚拟时钟上的一个定时器
+type vtimer struct {
+	when  time.Time
+	seq   uint64
+	fire  func(now time.Time)
+	index int //* 在堆中的位置，-1 表示已经不在堆中
+	clock *VirtualClock
+}
+
+func (t *vtimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+// *按 (when, seq) 排序的定时器堆
+type timerHeap []*vtimer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*vtimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
+
+// *虚拟时钟，时间只在调用 Advance 或开启 AutoAdvance 时前进
+type VirtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers timerHeap
+}
+
+// * 创建从 start 开始的虚拟时钟。
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.schedule(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.schedule(d, func(time.Time) { go f() })
+}
+
+func (c *VirtualClock) schedule(d time.Duration, fire func(now time.Time)) *vtimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d < 0 {
+		d = 0
+	}
+	c.seq += 1
+	t := &vtimer{when: c.now.Add(d), seq: c.seq, fire: fire, clock: c}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+// * 还没有触发的定时器数。
+func (c *VirtualClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// * 时间前进 d，依次触发期间到期的定时器。
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for c.fireNext(end) {
+	}
+	c.mu.Lock()
+	if c.now.Before(end) {
+		c.now = end
+	}
+	c.mu.Unlock()
+}
+
+// * 时间跳到最早的定时器并触发它，没有定时器时返回 false。
+func (c *VirtualClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	when := c.timers[0].when
+	c.mu.Unlock()
+	return c.fireNext(when)
+}
+
+// * 触发一个不晚于 limit 的定时器。
+func (c *VirtualClock) fireNext(limit time.Time) bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 || c.timers[0].when.After(limit) {
+		c.mu.Unlock()
+		return false
+	}
+	t := heap.Pop(&c.timers).(*vtimer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	now := c.now
+	c.mu.Unlock()
+	t.fire(now)
+	return true
+}
+
+// * 在后台自动推进时间：每隔真实时间 idle，把时间跳到下一个定时器。
+// * idle 是留给被唤醒的 goroutine 运行、安排下一个定时器的真实时间；
+// * 即使还有 goroutine 没运行完，时间也照样前进，所以 idle 太小时结果可能不同。
+// * 返回的函数用于停止自动推进。
+func (c *VirtualClock) AutoAdvance(idle time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(idle)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				c.AdvanceToNext()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,59 @@
+package labtime
+
+import "testing"
+import "time"
+
+func TestVirtualOrder(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewVirtualClock(start)
+
+	fired := make(chan int, 10)
+	add := func(d time.Duration, k int) Timer {
+		return c.AfterFunc(d, func() { fired <- k })
+	}
+
+	add(3*time.Second, 3)
+	add(1*time.Second, 1)
+	stopped := add(2*time.Second, 2)
+	ch := c.After(5 * time.Second)
+
+	if stopped.Stop() == false {
+		t.Fatalf("Stop() of pending timer returned false")
+	}
+
+	c.Advance(1 * time.Second)
+	if k := <-fired; k != 1 {
+		t.Fatalf("timer %v fired first", k)
+	}
+
+	c.Advance(10 * time.Second)
+	if k := <-fired; k != 3 {
+		t.Fatalf("timer %v fired second", k)
+	}
+	if now := <-ch; now != start.Add(5*time.Second) {
+		t.Fatalf("After fired at %v", now)
+	}
+	if c.Now() != start.Add(11*time.Second) || c.Pending() != 0 || len(fired) != 0 {
+		t.Fatalf("wrong Now() %v or Pending() %v", c.Now(), c.Pending())
+	}
+	if stopped.Stop() {
+		t.Fatalf("second Stop() returned true")
+	}
+}
+
+func TestAutoAdvance(t *testing.T) {
+	c := NewVirtualClock(time.Unix(0, 0))
+	stop := c.AutoAdvance(time.Millisecond)
+	defer stop()
+
+	t0 := time.Now()
+	for i := 0; i < 10; i++ {
+		c.Sleep(time.Hour)
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Fatalf("10 virtual hours took %v of real time", d)
+	}
+	if c.Now().Sub(time.Unix(0, 0)) != 10*time.Hour {
+		t.Fatalf("wrong virtual time %v", c.Now())
+	}
+}
@@ -0,0 +1,244 @@
+package raft
+
+//
+// 把 Raft 状态和快照保存在目录里的 Persister，进程重启后仍然存在。
+// 状态和快照写在同一个文件里，每次保存都先写临时文件、fsync，再 rename 覆盖，
+// 所以崩溃后看到的要么是旧的状态和快照，要么是新的，不会一半新一半旧。
+//
+// 文件格式：
+//
+//	magic "RFT1" | crc32c(之后的所有内容) | len(state) | state | len(snapshot) | snapshot
+//
+// 长度都是 8 字节大端整数。
+//
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	diskMagic    = "RFT1"
+	diskFileName = "persist"
+)
+
+// *读到的持久化文件已损坏
+var ErrCorrupt = errors.New("raft: persisted state is corrupt")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type DiskPersister struct {
+	mu        sync.Mutex
+	dir       string
+	raftstate []byte
+	snapshot  []byte
+	stats     PersistStats
+}
+
+// *打开目录 dir 中的持久化状态，目录不存在时创建。
+// *文件损坏时返回 ErrCorrupt。
+func MakeDiskPersister(dir string) (*DiskPersister, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	//* 上次崩溃可能留下没有 rename 的临时文件
+	os.Remove(filepath.Join(dir, diskFileName+".tmp"))
+
+	dp := &DiskPersister{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, diskFileName))
+	if os.IsNotExist(err) {
+		return dp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dp.raftstate, dp.snapshot, err = decodeDiskFile(data)
+	if err != nil {
+		return nil, err
+	}
+	return dp, nil
+}
+
+// *编码持久化文件。
+func encodeDiskFile(state []byte, snapshot []byte) []byte {
+	buf := make([]byte, 0, 8+16+len(state)+len(snapshot))
+	buf = append(buf, diskMagic...)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(state)))
+	buf = append(buf, state...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(snapshot)))
+	buf = append(buf, snapshot...)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], crcTable))
+	return buf
+}
+
+// *解码持久化文件，校验失败时返回 ErrCorrupt。
+func decodeDiskFile(data []byte) (state []byte, snapshot []byte, err error) {
+	if len(data) < 8+16 || string(data[:4]) != diskMagic {
+		return nil, nil, ErrCorrupt
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != crc32.Checksum(data[8:], crcTable) {
+		return nil, nil, ErrCorrupt
+	}
+	rest := data[8:]
+	n := binary.BigEndian.Uint64(rest)
+	if n > uint64(len(rest)-16) {
+		return nil, nil, ErrCorrupt
+	}
+	state = rest[8 : 8+n]
+	rest = rest[8+n:]
+	m := binary.BigEndian.Uint64(rest)
+	if m != uint64(len(rest)-8) {
+		return nil, nil, ErrCorrupt
+	}
+	snapshot = rest[8:]
+	if len(state) == 0 {
+		state = nil
+	}
+	if len(snapshot) == 0 {
+		snapshot = nil
+	}
+	return state, snapshot, nil
+}
+
+// *原子地把 data 写到 dir/name：临时文件 + fsync + rename + fsync 目录。
+func writeFileAtomic(dir string, name string, data []byte) error {
+	tmp := filepath.Join(dir, name+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// *写盘，调用者需持有 dp.mu。写盘失败时无法保证持久性，直接退出。
+// *每次都重写整个文件，所以写入的字节数包括没有变化的快照。
+func (dp *DiskPersister) persist(snapshot bool) {
+	n := dp.write()
+	dp.stats.record(dp.raftstate, snapshot, n)
+}
+
+// *写盘但不计入统计，返回写入的字节数，调用者需持有 dp.mu。
+func (dp *DiskPersister) write() int {
+	data := encodeDiskFile(dp.raftstate, dp.snapshot)
+	if err := writeFileAtomic(dp.dir, diskFileName, data); err != nil {
+		log.Fatalf("DiskPersister: write %v: %v\n", dp.dir, err)
+	}
+	return len(data)
+}
+
+// *获取持久化的统计
+func (dp *DiskPersister) Stats() PersistStats {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	return dp.stats
+}
+
+// *模拟重启：从同一个目录重新打开，统计从零开始。
+// *之后不应该再使用原来的 DiskPersister。
+func (dp *DiskPersister) Copy() *DiskPersister {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	np, err := MakeDiskPersister(dp.dir)
+	if err != nil {
+		log.Fatalf("DiskPersister.Copy(): %v\n", err)
+	}
+	return np
+}
+
+func (dp *DiskPersister) SaveRaftState(state []byte) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	dp.raftstate = clone(state)
+	dp.persist(false)
+}
+
+// *原样返回 state，不升级，同 Persister
+func (dp *DiskPersister) ReadRaftState() []byte {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	return dp.raftstate
+}
+
+// *读取 state，带版本的封装按注册的迁移函数升级到当前版本，
+// *升级后的 state 写回磁盘，不计入统计
+func (dp *DiskPersister) ReadRaftStateChecked() ([]byte, error) {
+	dp.mu.Lock()
+	state := dp.raftstate
+	dp.mu.Unlock()
+	up, changed, err := upgrade(state)
+	if changed {
+		dp.mu.Lock()
+		if same(dp.raftstate, state) {
+			dp.raftstate = up
+			dp.write()
+		}
+		dp.mu.Unlock()
+	}
+	return up, err
+}
+
+func (dp *DiskPersister) RaftStateSize() int {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	return len(dp.raftstate)
+}
+
+func (dp *DiskPersister) SaveStateAndSnapshot(state []byte, snapshot []byte) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	dp.raftstate = clone(state)
+	dp.snapshot = clone(snapshot)
+	dp.persist(true)
+}
+
+func (dp *DiskPersister) ReadSnapshot() []byte {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	return dp.snapshot
+}
+
+func (dp *DiskPersister) ReadSnapshotChecked() ([]byte, error) {
+	dp.mu.Lock()
+	snapshot := dp.snapshot
+	dp.mu.Unlock()
+	up, changed, err := upgrade(snapshot)
+	if changed {
+		dp.mu.Lock()
+		if same(dp.snapshot, snapshot) {
+			dp.snapshot = up
+			dp.write()
+		}
+		dp.mu.Unlock()
+	}
+	return up, err
+}
+
+func (dp *DiskPersister) SnapshotSize() int {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	return len(dp.snapshot)
+}
@@ -0,0 +1,141 @@
+package raft
+
+//
+// 模拟崩溃的 Persister。
+// 每次保存都被看作一次还没有 fsync 的写，直到下一次保存时前一次才算落盘。
+// Crash 按 CrashMode 决定崩溃时最后一次写变成了什么样子，返回重启后看到的 Persister。
+//
+
+import "sync"
+
+// *崩溃时最后一次写的结果
+type CrashMode int
+
+const (
+	CrashClean        CrashMode = iota //* 最后一次写已经完整落盘，同 Persister.Copy
+	CrashDropUnsynced                  //* 最后一次写完全丢失
+	CrashTruncate                      //* 最后一次写的状态只写了前一半
+	CrashCorrupt                       //* 最后一次写的状态有一个字节被破坏
+	CrashBetween                       //* SaveStateAndSnapshot 写完状态后、写快照前崩溃
+)
+
+func (m CrashMode) String() string {
+	switch m {
+	case CrashClean:
+		return "clean"
+	case CrashDropUnsynced:
+		return "drop-unsynced"
+	case CrashTruncate:
+		return "truncate"
+	case CrashCorrupt:
+		return "corrupt"
+	case CrashBetween:
+		return "between"
+	}
+	return "unknown"
+}
+
+type FaultyPersister struct {
+	mu           sync.Mutex
+	ps           *Persister
+	prevState    []byte //* 最后一次写之前已经落盘的状态
+	prevSnapshot []byte
+	lastSnapshot bool //* 最后一次写是否是 SaveStateAndSnapshot
+}
+
+// *创建一个 FaultyPersister，ps 为 nil 时从空状态开始。
+// *ps 中的状态或快照校验失败时返回错误，见 ReadPersisted。
+func MakeFaultyPersister(ps *Persister) (*FaultyPersister, error) {
+	if ps == nil {
+		ps = MakePersister()
+	}
+	fp := &FaultyPersister{ps: ps.Copy()}
+	var err error
+	if fp.prevState, fp.prevSnapshot, err = ReadPersisted(fp.ps); err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// *记录最后一次写之前的状态，调用者需持有 fp.mu。
+func (fp *FaultyPersister) sync() {
+	fp.ps.mu.Lock()
+	defer fp.ps.mu.Unlock()
+	fp.prevState = fp.ps.raftstate
+	fp.prevSnapshot = fp.ps.snapshot
+}
+
+func (fp *FaultyPersister) SaveRaftState(state []byte) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.sync()
+	fp.lastSnapshot = false
+	fp.ps.SaveRaftState(state)
+}
+
+func (fp *FaultyPersister) SaveStateAndSnapshot(state []byte, snapshot []byte) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.sync()
+	fp.lastSnapshot = true
+	fp.ps.SaveStateAndSnapshot(state, snapshot)
+}
+
+func (fp *FaultyPersister) ReadRaftState() []byte {
+	return fp.ps.ReadRaftState()
+}
+
+func (fp *FaultyPersister) ReadRaftStateChecked() ([]byte, error) {
+	return fp.ps.ReadRaftStateChecked()
+}
+
+func (fp *FaultyPersister) RaftStateSize() int {
+	return fp.ps.RaftStateSize()
+}
+
+func (fp *FaultyPersister) ReadSnapshot() []byte {
+	return fp.ps.ReadSnapshot()
+}
+
+func (fp *FaultyPersister) ReadSnapshotChecked() ([]byte, error) {
+	return fp.ps.ReadSnapshotChecked()
+}
+
+func (fp *FaultyPersister) SnapshotSize() int {
+	return fp.ps.SnapshotSize()
+}
+
+func (fp *FaultyPersister) Stats() PersistStats {
+	return fp.ps.Stats()
+}
+
+// *模拟一次崩溃，返回重启后看到的 Persister。
+// *状态非空时，CrashTruncate 和 CrashCorrupt 得到的状态会被 ReadPersisted 发现。
+func (fp *FaultyPersister) Crash(mode CrashMode) *Persister {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	np := fp.ps.Copy()
+	switch mode {
+	case CrashDropUnsynced:
+		np.raftstate = fp.prevState
+		np.snapshot = fp.prevSnapshot
+		np.stateSum = checksum(np.raftstate)
+		np.snapshotSum = checksum(np.snapshot)
+	case CrashTruncate:
+		//* 校验和仍然是完整状态的
+		np.raftstate = np.raftstate[:len(np.raftstate)/2]
+	case CrashCorrupt:
+		state := append([]byte{}, np.raftstate...)
+		if len(state) > 0 {
+			state[len(state)/2] ^= 0xff
+		}
+		np.raftstate = state
+	case CrashBetween:
+		if fp.lastSnapshot {
+			np.snapshot = fp.prevSnapshot
+			np.snapshotSum = checksum(np.snapshot)
+		}
+	}
+	return np
+}
@@ -0,0 +1,198 @@
+package raft
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gyy0727/mit-6.824/labgob"
+	"sync"
+)
+
+// *Raft 日志的存储。
+// *和 Persister 每次重写整个状态不同，LogStore 只追加新条目，
+// *持久化一个条目的代价与日志长度无关。
+// *日志下标从 1 开始；CompactPrefix 之后，被快照包含的最后一个条目的下标和任期
+// *仍然可以通过 Term 查到。
+type LogStore interface {
+	//* 第一个仍然保存着的条目的下标
+	FirstIndex() int
+	//* 最后一个条目的下标，日志为空时是 FirstIndex()-1
+	LastIndex() int
+	//* 下标为 index 的条目
+	Entry(index int) (LogEntry, error)
+	//* 下标在 [lo, hi) 中的条目
+	Entries(lo int, hi int) ([]LogEntry, error)
+	//* 下标为 index 的条目的任期，index 可以是 FirstIndex()-1
+	Term(index int) (int, error)
+	//* 在 LastIndex() 之后追加条目，返回时已经持久化
+	Append(entries ...LogEntry) error
+	//* 删除下标 >= index 的所有条目
+	TruncateSuffix(index int) error
+	//* 删除下标 <= index 的所有条目，它们已经包含在快照中，term 是下标 index 处的任期
+	CompactPrefix(index int, term int) error
+	//* 持久化的任期元数据
+	Meta() LogMeta
+	SetMeta(meta LogMeta) error
+	//* 存储占用的字节数
+	Size() int
+	Close() error
+}
+
+// *和日志一起持久化的元数据
+type LogMeta struct {
+	CurrentTerm int
+	VotedFor    int
+}
+
+var (
+	ErrCompacted   = errors.New("raft: log entry compacted")
+	ErrUnavailable = errors.New("raft: log entry unavailable")
+)
+
+// *只在内存中的 LogStore，用于测试和不需要持久化的场景
+type MemLogStore struct {
+	mu        sync.Mutex
+	entries   []LogEntry
+	sizes     []int //* 每个条目编码后的字节数
+	size      int
+	snapIndex int //* 被快照包含的最后一个条目的下标
+	snapTerm  int
+	meta      LogMeta
+}
+
+func MakeMemLogStore() *MemLogStore {
+	return &MemLogStore{}
+}
+
+// *条目编码后的字节数，用来和 RaftStateSize 比较。
+func encodedSize(e LogEntry) int {
+	w := new(bytes.Buffer)
+	labgob.NewEncoder(w).Encode(e)
+	return w.Len()
+}
+
+func (ms *MemLogStore) FirstIndex() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.snapIndex + 1
+}
+
+func (ms *MemLogStore) LastIndex() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.snapIndex + len(ms.entries)
+}
+
+// *检查 [lo, hi) 是否都在日志中，调用者需持有 ms.mu。
+func (ms *MemLogStore) check(lo int, hi int) error {
+	if lo <= ms.snapIndex {
+		return ErrCompacted
+	}
+	if hi > ms.snapIndex+len(ms.entries)+1 || lo > hi {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+func (ms *MemLogStore) Entry(index int) (LogEntry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if err := ms.check(index, index+1); err != nil {
+		return LogEntry{}, err
+	}
+	return ms.entries[index-ms.snapIndex-1], nil
+}
+
+func (ms *MemLogStore) Entries(lo int, hi int) ([]LogEntry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if err := ms.check(lo, hi); err != nil {
+		return nil, err
+	}
+	return append([]LogEntry{}, ms.entries[lo-ms.snapIndex-1:hi-ms.snapIndex-1]...), nil
+}
+
+func (ms *MemLogStore) Term(index int) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if index == ms.snapIndex {
+		return ms.snapTerm, nil
+	}
+	if err := ms.check(index, index+1); err != nil {
+		return 0, err
+	}
+	return ms.entries[index-ms.snapIndex-1].Term, nil
+}
+
+func (ms *MemLogStore) Append(entries ...LogEntry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, e := range entries {
+		n := encodedSize(e)
+		ms.entries = append(ms.entries, e)
+		ms.sizes = append(ms.sizes, n)
+		ms.size += n
+	}
+	return nil
+}
+
+func (ms *MemLogStore) TruncateSuffix(index int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if index <= ms.snapIndex {
+		return ErrCompacted
+	}
+	i := index - ms.snapIndex - 1
+	if i >= len(ms.entries) {
+		return nil
+	}
+	for _, n := range ms.sizes[i:] {
+		ms.size -= n
+	}
+	ms.entries = ms.entries[:i]
+	ms.sizes = ms.sizes[:i]
+	return nil
+}
+
+func (ms *MemLogStore) CompactPrefix(index int, term int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if index <= ms.snapIndex {
+		return nil
+	}
+	i := index - ms.snapIndex
+	if i > len(ms.entries) {
+		i = len(ms.entries)
+	}
+	for _, n := range ms.sizes[:i] {
+		ms.size -= n
+	}
+	//* 快照比日志还新时，整个日志都被丢弃
+	ms.entries = append([]LogEntry{}, ms.entries[i:]...)
+	ms.sizes = append([]int{}, ms.sizes[i:]...)
+	ms.snapIndex = index
+	ms.snapTerm = term
+	return nil
+}
+
+func (ms *MemLogStore) Meta() LogMeta {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.meta
+}
+
+func (ms *MemLogStore) SetMeta(meta LogMeta) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.meta = meta
+	return nil
+}
+
+func (ms *MemLogStore) Size() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.size
+}
+
+func (ms *MemLogStore) Close() error {
+	return nil
+}
@@ -1,26 +1,110 @@
 package raft
 
 import (
+	"bytes"
+	"github.com/gyy0727/mit-6.824/labgob"
+	"hash/crc32"
 	"sync"
 )
 
+// *Raft 使用的持久化存储。
+// *内存中的 Persister 和保存在磁盘上的 DiskPersister 都实现了它。
+// *保存时会拷贝传入的切片，调用者之后可以重用自己的缓冲区。
+type Storage interface {
+	SaveRaftState(state []byte)
+	ReadRaftState() []byte
+	ReadRaftStateChecked() ([]byte, error)
+	RaftStateSize() int
+	SaveStateAndSnapshot(state []byte, snapshot []byte)
+	ReadSnapshot() []byte
+	ReadSnapshotChecked() ([]byte, error)
+	SnapshotSize() int
+}
+
+// *持久化的统计
+type PersistStats struct {
+	SaveCalls     int64 //* SaveRaftState 的调用次数
+	SnapshotCalls int64 //* SaveStateAndSnapshot 的调用次数
+	BytesWritten  int64 //* 写入的总字节数
+	LargestState  int   //* 保存过的最大状态的字节数
+}
+
+// * 记录一次保存，写入了 n 个字节，调用者需持有相应的锁。
+func (st *PersistStats) record(state []byte, snapshot bool, n int) {
+	if snapshot {
+		st.SnapshotCalls += 1
+	} else {
+		st.SaveCalls += 1
+	}
+	st.BytesWritten += int64(n)
+	if len(state) > st.LargestState {
+		st.LargestState = len(state)
+	}
+}
+
+// *保存时记录状态和快照的校验和，读取时校验，
+// *这样 FaultyPersister 模拟出来的损坏能被发现，而不是被当成正常数据解码。
 type Persister struct {
-	mu        sync.Mutex
-	raftstate []byte
-	snapshot  []byte
+	mu          sync.Mutex
+	raftstate   []byte
+	snapshot    []byte
+	stateSum    uint32 //* raftstate 的 crc32c
+	snapshotSum uint32 //* snapshot 的 crc32c
+	stats       PersistStats
 }
 
 func MakePersister() *Persister {
-	return &Persister{}
+	return &Persister{stateSum: checksum(nil), snapshotSum: checksum(nil)}
+}
+
+func checksum(data []byte) uint32 {
+	return crc32.Checksum(data, crcTable)
+}
+
+// *保存前拷贝调用者的切片，避免调用者重用缓冲区后内容和校验和对不上
+func clone(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	return append([]byte{}, data...)
+}
+
+// *Raft 启动时读取持久化的状态和快照，两者都经过校验和升级。
+// *返回错误（例如 ErrCorrupt）时 Raft 应该拒绝启动，而不是解码损坏的数据。
+func ReadPersisted(st Storage) (state []byte, snapshot []byte, err error) {
+	if state, err = st.ReadRaftStateChecked(); err != nil {
+		return nil, nil, err
+	}
+	if snapshot, err = st.ReadSnapshotChecked(); err != nil {
+		return nil, nil, err
+	}
+	return state, snapshot, nil
+}
+
+// *升级带版本的封装，升级后内容变了时 changed 为 true。
+// *调用者应该把升级后的内容存回去，这样迁移只在第一次读取时执行。
+func upgrade(data []byte) (up []byte, changed bool, err error) {
+	up, err = labgob.Upgrade(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return up, !bytes.Equal(up, data), nil
+}
+
+// *a 和 b 是否是同一个切片，用来判断读取之后有没有人保存过新的内容
+func same(a []byte, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
 }
 
-// *拷贝Persister
+// *拷贝Persister，统计从零开始
 func (ps *Persister) Copy() *Persister {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	np := MakePersister()
 	np.raftstate = ps.raftstate
 	np.snapshot = ps.snapshot
+	np.stateSum = ps.stateSum
+	np.snapshotSum = ps.snapshotSum
 	return np
 }
 
@@ -28,16 +112,41 @@ func (ps *Persister) Copy() *Persister {
 func (ps *Persister) SaveRaftState(state []byte) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
-	ps.raftstate = state
+	ps.raftstate = clone(state)
+	ps.stateSum = checksum(ps.raftstate)
+	ps.stats.record(state, false, len(state))
 }
 
-// *读取Persister的state
+// *读取Persister的state，原样返回保存的字节，不校验也不升级。
+// *Raft 启动时应使用 ReadPersisted，才能发现损坏的状态。
 func (ps *Persister) ReadRaftState() []byte {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	return ps.raftstate
 }
 
+// *读取Persister的state，校验失败时返回 ErrCorrupt。
+// *state 是 labgob 的带版本封装时，按注册的迁移函数升级到当前版本，
+// *并把升级后的 state 存回去，不计入统计。
+func (ps *Persister) ReadRaftStateChecked() ([]byte, error) {
+	ps.mu.Lock()
+	state, sum := ps.raftstate, ps.stateSum
+	ps.mu.Unlock()
+	if checksum(state) != sum {
+		return nil, ErrCorrupt
+	}
+	up, changed, err := upgrade(state)
+	if changed {
+		ps.mu.Lock()
+		if same(ps.raftstate, state) {
+			ps.raftstate = up
+			ps.stateSum = checksum(up)
+		}
+		ps.mu.Unlock()
+	}
+	return up, err
+}
+
 // *获取state的长度
 func (ps *Persister) RaftStateSize() int {
 	ps.mu.Lock()
@@ -49,18 +158,49 @@ func (ps *Persister) RaftStateSize() int {
 func (ps *Persister) SaveStateAndSnapshot(state []byte, snapshot []byte) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
-	ps.raftstate = state
-	ps.snapshot = snapshot
+	ps.raftstate = clone(state)
+	ps.snapshot = clone(snapshot)
+	ps.stateSum = checksum(ps.raftstate)
+	ps.snapshotSum = checksum(ps.snapshot)
+	ps.stats.record(state, true, len(state)+len(snapshot))
 }
 
+// *读取快照，原样返回保存的字节，不校验也不升级，见 ReadSnapshotChecked
 func (ps *Persister) ReadSnapshot() []byte {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	return ps.snapshot
 }
 
+// *读取快照，校验失败时返回 ErrCorrupt，带版本的封装同样会被升级并存回去
+func (ps *Persister) ReadSnapshotChecked() ([]byte, error) {
+	ps.mu.Lock()
+	snapshot, sum := ps.snapshot, ps.snapshotSum
+	ps.mu.Unlock()
+	if checksum(snapshot) != sum {
+		return nil, ErrCorrupt
+	}
+	up, changed, err := upgrade(snapshot)
+	if changed {
+		ps.mu.Lock()
+		if same(ps.snapshot, snapshot) {
+			ps.snapshot = up
+			ps.snapshotSum = checksum(up)
+		}
+		ps.mu.Unlock()
+	}
+	return up, err
+}
+
 func (ps *Persister) SnapshotSize() int {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	return len(ps.snapshot)
 }
+
+// *获取持久化的统计
+func (ps *Persister) Stats() PersistStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.stats
+}
@@ -0,0 +1,459 @@
+package raft
+
+//
+// 分段保存在磁盘上的 LogStore。
+// 日志被切成若干段文件，文件名是段中第一个条目的下标；只有最后一段会被追加。
+// 每个记录是：
+//
+//	len(payload) | crc32c(payload) | payload
+//
+// len 和 crc 都是 4 字节大端整数，payload 是 labgob 编码的 logRecord。
+// 元数据（任期、投票、快照的下标和任期）保存在 meta 文件中，用 writeFileAtomic 整体替换。
+// 打开时最后一段末尾不完整的记录（追加到一半时崩溃）会被截掉。
+//
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/gyy0727/mit-6.824/labgob"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultSegmentSize = 4 << 20
+	segmentSuffix      = ".log"
+	metaFileName       = "meta"
+)
+
+// *段文件中的一个记录
+type logRecord struct {
+	Index   int
+	Term    int
+	Command interface{}
+}
+
+// *meta 文件的内容
+type segmentMeta struct {
+	Meta      LogMeta
+	SnapIndex int
+	SnapTerm  int
+}
+
+// *一个段文件
+type segment struct {
+	first int   //* 段中第一个条目的下标
+	next  int   //* 段中最后一个条目的下标加一
+	size  int64 //* 文件大小
+	path  string
+}
+
+// *一个条目在段文件中的位置
+type recordLoc struct {
+	seg *segment
+	off int64
+}
+
+type SegmentLogStore struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	meta        segmentMeta
+	entries     []LogEntry  //* 下标从 meta.SnapIndex+1 开始的条目
+	locs        []recordLoc //* 与 entries 一一对应
+	segs        []*segment  //* 按 first 排序
+	active      *os.File    //* 最后一段，以追加方式打开；nil 表示下次追加时新建一段
+}
+
+// *打开目录 dir 中的日志，目录不存在时创建。
+// *segmentSize 是一段的目标大小，<= 0 时使用默认值 4MB。
+func MakeSegmentLogStore(dir string, segmentSize int64) (*SegmentLogStore, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	os.Remove(filepath.Join(dir, metaFileName+".tmp"))
+
+	s := &SegmentLogStore{dir: dir, segmentSize: segmentSize}
+	if err := s.loadMeta(); err != nil {
+		return nil, err
+	}
+	if err := s.loadSegments(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SegmentLogStore) loadMeta() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, metaFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != crc32.Checksum(data[4:], crcTable) {
+		return ErrCorrupt
+	}
+	return labgob.NewDecoder(bytes.NewBuffer(data[4:])).Decode(&s.meta)
+}
+
+// *保存元数据，调用者需持有 s.mu。
+func (s *SegmentLogStore) saveMeta() error {
+	w := new(bytes.Buffer)
+	w.Write(make([]byte, 4))
+	if err := labgob.NewEncoder(w).Encode(s.meta); err != nil {
+		return err
+	}
+	data := w.Bytes()
+	binary.BigEndian.PutUint32(data, crc32.Checksum(data[4:], crcTable))
+	return writeFileAtomic(s.dir, metaFileName, data)
+}
+
+func (s *SegmentLogStore) loadSegments() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+segmentSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		first, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), segmentSuffix))
+		if err != nil {
+			continue
+		}
+		s.segs = append(s.segs, &segment{first: first, path: path})
+	}
+	sort.Slice(s.segs, func(i, j int) bool { return s.segs[i].first < s.segs[j].first })
+
+	for i, seg := range s.segs {
+		if i > 0 && seg.first != s.segs[i-1].next {
+			return ErrCorrupt
+		}
+		if err := s.scanSegment(seg, i == len(s.segs)-1); err != nil {
+			return err
+		}
+	}
+	if err := s.dropCompacted(); err != nil {
+		return err
+	}
+	if len(s.segs) > 0 {
+		s.active, err = os.OpenFile(s.segs[len(s.segs)-1].path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// *读取一段中的记录。只有最后一段允许有不完整的尾部，它会被截掉。
+func (s *SegmentLogStore) scanSegment(seg *segment, last bool) error {
+	data, err := os.ReadFile(seg.path)
+	if err != nil {
+		return err
+	}
+	off := 0
+	index := seg.first
+	for off < len(data) {
+		rec, n, ok := decodeRecord(data[off:])
+		if !ok {
+			if !last {
+				return ErrCorrupt
+			}
+			if err := os.Truncate(seg.path, int64(off)); err != nil {
+				return err
+			}
+			break
+		}
+		if rec.Index != index {
+			return ErrCorrupt
+		}
+		if rec.Index > s.meta.SnapIndex {
+			if len(s.entries) == 0 && rec.Index != s.meta.SnapIndex+1 {
+				//* 快照和日志之间有空洞
+				return ErrCorrupt
+			}
+			s.entries = append(s.entries, LogEntry{rec.Command, rec.Term})
+			s.locs = append(s.locs, recordLoc{seg, int64(off)})
+		}
+		off += n
+		index++
+	}
+	seg.next = index
+	seg.size = int64(off)
+	return nil
+}
+
+// *编码一个记录。
+func encodeRecord(w *bytes.Buffer, index int, e LogEntry) error {
+	start := w.Len()
+	w.Write(make([]byte, 8))
+	if err := labgob.NewEncoder(w).Encode(logRecord{index, e.Term, e.Command}); err != nil {
+		w.Truncate(start)
+		return err
+	}
+	b := w.Bytes()[start:]
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(b)-8))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(b[8:], crcTable))
+	return nil
+}
+
+// *解码 data 开头的记录，返回记录和它占用的字节数；记录不完整或校验失败时 ok 为 false。
+func decodeRecord(data []byte) (rec logRecord, n int, ok bool) {
+	if len(data) < 8 {
+		return rec, 0, false
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size > len(data)-8 {
+		return rec, 0, false
+	}
+	payload := data[8 : 8+size]
+	if binary.BigEndian.Uint32(data[4:8]) != crc32.Checksum(payload, crcTable) {
+		return rec, 0, false
+	}
+	if err := labgob.NewDecoder(bytes.NewBuffer(payload)).Decode(&rec); err != nil {
+		return rec, 0, false
+	}
+	return rec, 8 + size, true
+}
+
+// *删除所有条目都已经包含在快照中的段，调用者需持有 s.mu。
+func (s *SegmentLogStore) dropCompacted() error {
+	for len(s.segs) > 0 && s.segs[0].next <= s.meta.SnapIndex+1 {
+		if len(s.segs) == 1 && s.active != nil {
+			s.active.Close()
+			s.active = nil
+		}
+		if err := os.Remove(s.segs[0].path); err != nil {
+			return err
+		}
+		s.segs = s.segs[1:]
+	}
+	return nil
+}
+
+// *新建一段，第一个条目的下标是 first，调用者需持有 s.mu。
+func (s *SegmentLogStore) newSegment(first int) error {
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%v", first, segmentSuffix))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	d, err := os.Open(s.dir)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	s.active = f
+	s.segs = append(s.segs, &segment{first: first, next: first, path: path})
+	return nil
+}
+
+func (s *SegmentLogStore) FirstIndex() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.meta.SnapIndex + 1
+}
+
+func (s *SegmentLogStore) LastIndex() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.meta.SnapIndex + len(s.entries)
+}
+
+// *检查 [lo, hi) 是否都在日志中，调用者需持有 s.mu。
+func (s *SegmentLogStore) check(lo int, hi int) error {
+	if lo <= s.meta.SnapIndex {
+		return ErrCompacted
+	}
+	if hi > s.meta.SnapIndex+len(s.entries)+1 || lo > hi {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+func (s *SegmentLogStore) Entry(index int) (LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(index, index+1); err != nil {
+		return LogEntry{}, err
+	}
+	return s.entries[index-s.meta.SnapIndex-1], nil
+}
+
+func (s *SegmentLogStore) Entries(lo int, hi int) ([]LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(lo, hi); err != nil {
+		return nil, err
+	}
+	return append([]LogEntry{}, s.entries[lo-s.meta.SnapIndex-1:hi-s.meta.SnapIndex-1]...), nil
+}
+
+func (s *SegmentLogStore) Term(index int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index == s.meta.SnapIndex {
+		return s.meta.SnapTerm, nil
+	}
+	if err := s.check(index, index+1); err != nil {
+		return 0, err
+	}
+	return s.entries[index-s.meta.SnapIndex-1].Term, nil
+}
+
+// *把条目写到最后一段并 fsync，一次 Append 只 fsync 一次。
+func (s *SegmentLogStore) Append(entries ...LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	next := s.meta.SnapIndex + len(s.entries) + 1
+	if s.active == nil || s.segs[len(s.segs)-1].size >= s.segmentSize {
+		if err := s.newSegment(next); err != nil {
+			return err
+		}
+	}
+	seg := s.segs[len(s.segs)-1]
+
+	w := new(bytes.Buffer)
+	offs := make([]int64, len(entries))
+	for i, e := range entries {
+		offs[i] = seg.size + int64(w.Len())
+		if err := encodeRecord(w, next+i, e); err != nil {
+			return err
+		}
+	}
+	if _, err := s.active.Write(w.Bytes()); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	seg.size += int64(w.Len())
+	seg.next += len(entries)
+	for i, e := range entries {
+		s.entries = append(s.entries, e)
+		s.locs = append(s.locs, recordLoc{seg, offs[i]})
+	}
+	return nil
+}
+
+func (s *SegmentLogStore) TruncateSuffix(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index <= s.meta.SnapIndex {
+		return ErrCompacted
+	}
+	i := index - s.meta.SnapIndex - 1
+	if i >= len(s.entries) {
+		return nil
+	}
+	loc := s.locs[i]
+
+	//* 删除后面的段，截断 index 所在的段，并让它成为最后一段
+	k := 0
+	for s.segs[k] != loc.seg {
+		k++
+	}
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+	for _, seg := range s.segs[k+1:] {
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+	}
+	s.segs = s.segs[:k+1]
+	if err := os.Truncate(loc.seg.path, loc.off); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(loc.seg.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	s.active = f
+	loc.seg.size = loc.off
+	loc.seg.next = index
+	s.entries = s.entries[:i]
+	s.locs = s.locs[:i]
+	return nil
+}
+
+// *先持久化新的快照下标，再删除被快照包含的段。
+// *在两步之间崩溃也没关系：打开时会跳过并删除这些段。
+func (s *SegmentLogStore) CompactPrefix(index int, term int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index <= s.meta.SnapIndex {
+		return nil
+	}
+	i := index - s.meta.SnapIndex
+	if i > len(s.entries) {
+		i = len(s.entries)
+	}
+	s.meta.SnapIndex = index
+	s.meta.SnapTerm = term
+	if err := s.saveMeta(); err != nil {
+		return err
+	}
+	s.entries = append([]LogEntry{}, s.entries[i:]...)
+	s.locs = append([]recordLoc{}, s.locs[i:]...)
+	return s.dropCompacted()
+}
+
+func (s *SegmentLogStore) Meta() LogMeta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.meta.Meta
+}
+
+func (s *SegmentLogStore) SetMeta(meta LogMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta.Meta = meta
+	return s.saveMeta()
+}
+
+// *所有段文件的总大小。
+func (s *SegmentLogStore) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, seg := range s.segs {
+		n += seg.size
+	}
+	return int(n)
+}
+
+func (s *SegmentLogStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		err := s.active.Close()
+		s.active = nil
+		return err
+	}
+	return nil
+}
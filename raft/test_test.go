@@ -0,0 +1,369 @@
+package raft
+
+import "testing"
+import "bytes"
+import "os"
+import "path/filepath"
+import "github.com/gyy0727/mit-6.824/labgob"
+import "errors"
+
+var _ Storage = &Persister{}
+var _ Storage = &DiskPersister{}
+
+func TestDiskPersister(t *testing.T) {
+	dir := t.TempDir()
+
+	dp, err := MakeDiskPersister(dir)
+	if err != nil {
+		t.Fatalf("MakeDiskPersister: %v", err)
+	}
+	if dp.ReadRaftState() != nil || dp.ReadSnapshot() != nil {
+		t.Fatalf("new DiskPersister is not empty")
+	}
+
+	dp.SaveStateAndSnapshot([]byte("state1"), []byte("snap1"))
+	dp.SaveRaftState([]byte("state2"))
+
+	//* 重启后状态和快照都还在。
+	dp = dp.Copy()
+	if string(dp.ReadRaftState()) != "state2" || string(dp.ReadSnapshot()) != "snap1" {
+		t.Fatalf("wrong state after restart: %q %q", dp.ReadRaftState(), dp.ReadSnapshot())
+	}
+	if dp.RaftStateSize() != 6 || dp.SnapshotSize() != 5 {
+		t.Fatalf("wrong sizes %v %v", dp.RaftStateSize(), dp.SnapshotSize())
+	}
+
+	//* 文件被破坏后拒绝打开。
+	path := filepath.Join(dir, diskFileName)
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	if _, err := MakeDiskPersister(dir); err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+
+	//* 截断的文件也一样。
+	os.WriteFile(path, data[:len(data)/2], 0644)
+	if _, err := MakeDiskPersister(dir); err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt for truncated file, got %v", err)
+	}
+}
+
+func TestDiskFileFormat(t *testing.T) {
+	for _, c := range [][2][]byte{{nil, nil}, {[]byte("x"), nil}, {nil, []byte("y")}, {bytes.Repeat([]byte("z"), 1000), []byte("w")}} {
+		state, snapshot, err := decodeDiskFile(encodeDiskFile(c[0], c[1]))
+		if err != nil || !bytes.Equal(state, c[0]) || !bytes.Equal(snapshot, c[1]) {
+			t.Fatalf("round trip of %q %q gave %q %q %v", c[0], c[1], state, snapshot, err)
+		}
+	}
+}
+
+var _ LogStore = &MemLogStore{}
+var _ LogStore = &SegmentLogStore{}
+
+// 对任意 LogStore 都成立的行为。
+func checkLogStore(t *testing.T, ls LogStore) {
+	if ls.FirstIndex() != 1 || ls.LastIndex() != 0 {
+		t.Fatalf("new log has first %v last %v", ls.FirstIndex(), ls.LastIndex())
+	}
+	for i := 1; i <= 10; i++ {
+		if err := ls.Append(LogEntry{i * 100, 1 + i/5}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if ls.LastIndex() != 10 {
+		t.Fatalf("wrong LastIndex %v", ls.LastIndex())
+	}
+	if e, err := ls.Entry(7); err != nil || e.Command != 700 || e.Term != 2 {
+		t.Fatalf("wrong entry 7: %v %v", e, err)
+	}
+	if es, err := ls.Entries(3, 6); err != nil || len(es) != 3 || es[0].Command != 300 {
+		t.Fatalf("wrong Entries(3, 6): %v %v", es, err)
+	}
+	if _, err := ls.Entry(11); err != ErrUnavailable {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+
+	if err := ls.TruncateSuffix(8); err != nil {
+		t.Fatalf("TruncateSuffix: %v", err)
+	}
+	ls.Append(LogEntry{"x", 3})
+	if ls.LastIndex() != 8 {
+		t.Fatalf("wrong LastIndex %v after truncate", ls.LastIndex())
+	}
+	if e, _ := ls.Entry(8); e.Command != "x" {
+		t.Fatalf("wrong entry 8 after truncate: %v", e)
+	}
+
+	if err := ls.CompactPrefix(4, 1); err != nil {
+		t.Fatalf("CompactPrefix: %v", err)
+	}
+	if ls.FirstIndex() != 5 || ls.LastIndex() != 8 {
+		t.Fatalf("wrong range [%v, %v] after compact", ls.FirstIndex(), ls.LastIndex())
+	}
+	if term, err := ls.Term(4); err != nil || term != 1 {
+		t.Fatalf("wrong term at snapshot index: %v %v", term, err)
+	}
+	if _, err := ls.Entry(4); err != ErrCompacted {
+		t.Fatalf("expected ErrCompacted, got %v", err)
+	}
+	if err := ls.TruncateSuffix(3); err != ErrCompacted {
+		t.Fatalf("expected ErrCompacted from TruncateSuffix, got %v", err)
+	}
+
+	ls.SetMeta(LogMeta{5, 2})
+	if ls.Meta() != (LogMeta{5, 2}) {
+		t.Fatalf("wrong meta %v", ls.Meta())
+	}
+
+	//* 快照比日志新时日志被清空，之后从快照下标后继续追加。
+	if err := ls.CompactPrefix(20, 4); err != nil {
+		t.Fatalf("CompactPrefix: %v", err)
+	}
+	if ls.FirstIndex() != 21 || ls.LastIndex() != 20 || ls.Size() != 0 {
+		t.Fatalf("wrong state after compacting everything: [%v, %v] size %v", ls.FirstIndex(), ls.LastIndex(), ls.Size())
+	}
+	ls.Append(LogEntry{"y", 4})
+	if e, err := ls.Entry(21); err != nil || e.Command != "y" {
+		t.Fatalf("wrong entry 21: %v %v", e, err)
+	}
+}
+
+func TestMemLogStore(t *testing.T) {
+	checkLogStore(t, MakeMemLogStore())
+}
+
+func TestSegmentLogStore(t *testing.T) {
+	dir := t.TempDir()
+	ls, err := MakeSegmentLogStore(dir, 64)
+	if err != nil {
+		t.Fatalf("MakeSegmentLogStore: %v", err)
+	}
+	checkLogStore(t, ls)
+	ls.Append(LogEntry{"z", 4})
+	ls.Close()
+
+	//* 重新打开后日志、快照下标和元数据都还在。
+	ls, err = MakeSegmentLogStore(dir, 64)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer ls.Close()
+	if ls.FirstIndex() != 21 || ls.LastIndex() != 22 || ls.Meta() != (LogMeta{5, 2}) {
+		t.Fatalf("wrong state after reopen: [%v, %v] %v", ls.FirstIndex(), ls.LastIndex(), ls.Meta())
+	}
+	if term, _ := ls.Term(20); term != 4 {
+		t.Fatalf("wrong snapshot term %v after reopen", term)
+	}
+	if e, _ := ls.Entry(22); e.Command != "z" {
+		t.Fatalf("wrong entry 22 after reopen: %v", e)
+	}
+}
+
+func TestSegmentLogStoreTornTail(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := MakeSegmentLogStore(dir, 1<<20)
+	for i := 1; i <= 5; i++ {
+		ls.Append(LogEntry{i, 1})
+	}
+	ls.Close()
+
+	//* 模拟追加到一半时崩溃：最后一个记录只写了一部分。
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	data, _ := os.ReadFile(paths[0])
+	os.WriteFile(paths[0], data[:len(data)-3], 0644)
+
+	ls, err := MakeSegmentLogStore(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("reopen with torn tail: %v", err)
+	}
+	if ls.LastIndex() != 4 {
+		t.Fatalf("expected torn entry to be dropped, LastIndex %v", ls.LastIndex())
+	}
+	ls.Append(LogEntry{"new", 2})
+	ls.Close()
+
+	ls, _ = MakeSegmentLogStore(dir, 1<<20)
+	defer ls.Close()
+	if e, err := ls.Entry(5); err != nil || e.Command != "new" {
+		t.Fatalf("wrong entry after recovery: %v %v", e, err)
+	}
+}
+
+// 逐条追加 n 个条目时，每次把整个日志写进 Persister 和只追加新条目写入的字节数。
+func BenchmarkPersistLog(b *testing.B) {
+	cmd := string(bytes.Repeat([]byte("c"), 100))
+	const n = 1000
+
+	b.Run("SaveRaftState", func(b *testing.B) {
+		var written int
+		for i := 0; i < b.N; i++ {
+			ps := MakePersister()
+			log := []LogEntry{}
+			for j := 0; j < n; j++ {
+				log = append(log, LogEntry{cmd, 1})
+				w := new(bytes.Buffer)
+				labgob.NewEncoder(w).Encode(log)
+				ps.SaveRaftState(w.Bytes())
+				written += ps.RaftStateSize()
+			}
+		}
+		b.ReportMetric(float64(written)/float64(b.N*n), "bytes/append")
+	})
+
+	b.Run("SegmentLogStore", func(b *testing.B) {
+		var written int
+		for i := 0; i < b.N; i++ {
+			ls, _ := MakeSegmentLogStore(b.TempDir(), 0)
+			for j := 0; j < n; j++ {
+				before := ls.Size()
+				ls.Append(LogEntry{cmd, 1})
+				written += ls.Size() - before
+			}
+			ls.Close()
+		}
+		b.ReportMetric(float64(written)/float64(b.N*n), "bytes/append")
+	})
+}
+
+var _ Storage = &FaultyPersister{}
+
+func TestFaultyPersister(t *testing.T) {
+	fp, _ := MakeFaultyPersister(nil)
+	fp.SaveStateAndSnapshot([]byte("state1"), []byte("snap1"))
+	fp.SaveStateAndSnapshot([]byte("state2"), []byte("snap2"))
+
+	check := func(mode CrashMode, state string, snapshot string) {
+		ps := fp.Crash(mode)
+		s, err1 := ps.ReadRaftStateChecked()
+		sn, err2 := ps.ReadSnapshotChecked()
+		if err1 != nil || err2 != nil || string(s) != state || string(sn) != snapshot {
+			t.Fatalf("%v: got %q %q %v %v, want %q %q", mode, s, sn, err1, err2, state, snapshot)
+		}
+	}
+	check(CrashClean, "state2", "snap2")
+	check(CrashDropUnsynced, "state1", "snap1")
+	check(CrashBetween, "state2", "snap1")
+
+	//* 被截断或破坏的状态不能被读出来。
+	for _, mode := range []CrashMode{CrashTruncate, CrashCorrupt} {
+		ps := fp.Crash(mode)
+		if _, _, err := ReadPersisted(ps); err != ErrCorrupt {
+			t.Fatalf("%v: expected ErrCorrupt, got %v", mode, err)
+		}
+		if _, err := MakeFaultyPersister(ps); err != ErrCorrupt {
+			t.Fatalf("%v: MakeFaultyPersister accepted corrupt state: %v", mode, err)
+		}
+		//* ReadRaftState 不校验，原样返回
+		if s := ps.ReadRaftState(); string(s) == "state2" || len(s) == 0 {
+			t.Fatalf("%v: ReadRaftState returned %q", mode, s)
+		}
+	}
+
+	//* 只保存状态时，CrashBetween 等同于干净的崩溃。
+	fp.SaveRaftState([]byte("state3"))
+	check(CrashBetween, "state3", "snap2")
+	check(CrashDropUnsynced, "state2", "snap2")
+}
+
+// 保存时拷贝调用者的切片，调用者重用缓冲区不影响已保存的状态。
+func TestPersisterCopiesOnSave(t *testing.T) {
+	dp, _ := MakeDiskPersister(t.TempDir())
+	fp, _ := MakeFaultyPersister(nil)
+	for _, st := range []Storage{MakePersister(), fp, dp} {
+		buf := []byte("state1")
+		snap := []byte("snap1")
+		st.SaveStateAndSnapshot(buf, snap)
+		copy(buf, "XXXXXX")
+		copy(snap, "YYYYY")
+		s, err1 := st.ReadRaftStateChecked()
+		sn, err2 := st.ReadSnapshotChecked()
+		if err1 != nil || err2 != nil || string(s) != "state1" || string(sn) != "snap1" {
+			t.Fatalf("%T: got %q %q %v %v after reusing the buffers", st, s, sn, err1, err2)
+		}
+		st.SaveRaftState(buf)
+		copy(buf, "state3")
+		if s, err := st.ReadRaftStateChecked(); err != nil || string(s) != "XXXXXX" {
+			t.Fatalf("%T: got %q %v after reusing the buffer", st, s, err)
+		}
+	}
+}
+
+func TestPersistStats(t *testing.T) {
+	ps := MakePersister()
+	ps.SaveRaftState([]byte("abc"))
+	ps.SaveRaftState([]byte("abcdef"))
+	ps.SaveStateAndSnapshot([]byte("ab"), []byte("snapshot"))
+	st := ps.Stats()
+	if st.SaveCalls != 2 || st.SnapshotCalls != 1 || st.BytesWritten != 3+6+2+8 || st.LargestState != 6 {
+		t.Fatalf("wrong stats %+v", st)
+	}
+	if ps.Copy().Stats() != (PersistStats{}) {
+		t.Fatalf("Copy did not reset stats")
+	}
+
+	//* DiskPersister 每次都重写快照，写入的字节数比状态本身多。
+	dp, _ := MakeDiskPersister(t.TempDir())
+	dp.SaveStateAndSnapshot([]byte("ab"), []byte("snapshot"))
+	dp.SaveRaftState([]byte("abc"))
+	st = dp.Stats()
+	if st.SaveCalls != 1 || st.SnapshotCalls != 1 || st.BytesWritten != 2*24+2+8+3+8 {
+		t.Fatalf("wrong disk stats %+v", st)
+	}
+}
+
+func TestPersisterMigration(t *testing.T) {
+	const name = "raft.TestPersistedState"
+	type stateV1 struct{ Term int }
+	type stateV2 struct{ Term, Epoch int }
+
+	ps := MakePersister()
+	state, _ := labgob.MarshalVersioned(labgob.Gob, name, stateV1{5})
+	ps.SaveRaftState(state)
+
+	labgob.RegisterVersion(name, 2)
+	defer labgob.RegisterVersion(name, 1)
+	if _, err := ps.ReadRaftStateChecked(); !errors.Is(err, labgob.ErrNoMigration) {
+		t.Fatalf("expected ErrNoMigration, got %v", err)
+	}
+
+	migrations := 0
+	labgob.RegisterMigration(name, 1, func(payload []byte) ([]byte, error) {
+		migrations += 1
+		var old stateV1
+		if err := labgob.Gob.Unmarshal(payload, &old); err != nil {
+			return nil, err
+		}
+		return labgob.Gob.Marshal(stateV2{old.Term, 1})
+	})
+	dp, _ := MakeDiskPersister(t.TempDir())
+	dp.SaveStateAndSnapshot(state, state)
+	for _, st := range []Storage{ps, dp} {
+		migrations = 0
+		for i := 0; i < 2; i++ {
+			up, err := st.ReadRaftStateChecked()
+			if err != nil {
+				t.Fatalf("ReadRaftStateChecked: %v", err)
+			}
+			var s stateV2
+			if err := labgob.UnmarshalVersioned(labgob.Gob, name, up, &s); err != nil || s != (stateV2{5, 1}) {
+				t.Fatalf("wrong upgraded state %+v %v", s, err)
+			}
+		}
+		//* 升级后的状态已经存回去，第二次读取不再迁移，原样读取也是新版本
+		if migrations != 1 {
+			t.Fatalf("migration ran %v times", migrations)
+		}
+		if _, v, _, _ := labgob.Open(st.ReadRaftState()); v != 2 {
+			t.Fatalf("upgraded state was not saved back, version %v", v)
+		}
+	}
+
+	if _, err := dp.ReadSnapshotChecked(); err != nil {
+		t.Fatalf("ReadSnapshotChecked: %v", err)
+	}
+	dp2, _ := MakeDiskPersister(dp.dir)
+	if _, v, _, _ := labgob.Open(dp2.ReadSnapshot()); v != 2 {
+		t.Fatalf("upgraded snapshot was not written to disk, version %v", v)
+	}
+}